@@ -1,30 +1,71 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jmartin82/mkpis/internal/config"
 	"github.com/jmartin82/mkpis/internal/csv"
+	"github.com/jmartin82/mkpis/internal/httpcache"
 	"github.com/jmartin82/mkpis/internal/json"
+	"github.com/jmartin82/mkpis/internal/replay"
+	"github.com/jmartin82/mkpis/internal/retry"
+	"github.com/jmartin82/mkpis/internal/store"
 	"github.com/jmartin82/mkpis/internal/ui"
 
 	"github.com/jmartin82/mkpis/pkg/vcs"
+	"github.com/jmartin82/mkpis/pkg/vcs/azapi"
+	"github.com/jmartin82/mkpis/pkg/vcs/bbapi"
 	"github.com/jmartin82/mkpis/pkg/vcs/ghapi"
+	"github.com/jmartin82/mkpis/pkg/vcs/gitlocal"
+	"github.com/jmartin82/mkpis/pkg/vcs/glapi"
+	"github.com/jmartin82/mkpis/pkg/vcs/grapi"
+	"github.com/jmartin82/mkpis/pkg/vcs/gtapi"
 )
 
 type renderer struct {
-	renderSingle func(pr vcs.PR) error
-	render       func(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator bool) error
+	renderSingle func(pr vcs.PR, opts vcs.KPIOptions) error
+	render       func(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, groupBy string, opts vcs.KPIOptions) error
 }
 
+// clientOptions are the command line settings of the VCS clients.
+type clientOptions struct {
+	provider  string
+	gitDir    string
+	graphql   bool
+	replaying bool
+	github    ghapi.Options
+}
+
+// reportOptions are the command line settings of the reports.
+type reportOptions struct {
+	includeCreator bool
+	strict         bool
+	kpi            vcs.KPIOptions
+	groupBy        string
+	labels         []string
+	excludeLabels  []string
+}
+
+// retryWait is the wait before the first retry of a failed GitHub request.
+const retryWait = time.Second
+
+// defaultStoreDir is where `mkpis sync` keeps the PRs when -store is not set.
+const defaultStoreDir = ".mkpis"
+
 func printError(err string) {
 	fmt.Fprintf(os.Stderr, "Error: %s\n\n", err)
 
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage of %s [sync]:\n", os.Args[0])
 	flag.PrintDefaults()
 
 }
@@ -40,26 +81,52 @@ func main() {
 	log.Println("Starting MKPIS Appplication")
 	owner := flag.String("owner", "", "Owner of the repository")
 	repo := flag.String("repo", "", "Repository name")
-	base := flag.String("base", "master", "Base branch to check for PRs")
+	provider := flag.String("provider", "github", "VCS provider hosting the repository (github, gitlab, bitbucket, bitbucket-server, gitea, azure, gerrit, git)")
+	graphql := flag.Bool("graphql", false, "If set, the github provider uses the GraphQL API, which needs far fewer requests")
+	githubURL := flag.String("github-url", config.Env.GitHubURL, "API URL of a GitHub Enterprise Server, e.g. https://github.example.com/api/v3/")
+	githubUploadURL := flag.String("github-upload-url", config.Env.GitHubUploadURL, "Upload URL of a GitHub Enterprise Server. Derived from 'github-url' if empty")
+	concurrency := flag.Int("concurrency", 4, "Number of PRs fetched in parallel by the github provider")
+	checkRateLimit := flag.Bool("check-rate-limit", false, "If set, the github provider refuses to fetch PRs when the rate limit left can't cover them. Not supported with -graphql")
+	record := flag.String("record", "", "Directory where the GitHub responses are recorded for later replay")
+	replayDir := flag.String("replay", "", "Directory of recorded GitHub responses to serve instead of calling the API")
+	retries := flag.Int("retries", 3, "Number of times a GitHub request failing with a 5xx error, a timeout or a connection reset is retried")
+	cacheDir := flag.String("cache", "", "Directory where GitHub responses are cached and revalidated with conditional requests")
+	gitDir := flag.String("git-dir", ".", "Path of the local clone read by the git provider")
+	storeDir := flag.String("store", "", "Directory of the local PR store filled by 'sync'. If set, reports are computed from the store without calling the provider")
+	base := flag.String("base", "master", "Comma separated base branches to check for PRs. Accepts glob patterns such as 'release/*', or '*' for all branches")
+	groupBy := flag.String("group-by", "", "If set to 'base' or 'label', the report has a table per base branch or per label")
+	label := flag.String("label", "", "Comma separated labels. If set, only the PRs with any of them are reported")
+	excludeLabel := flag.String("exclude-label", "", "Comma separated labels. If set, the PRs with any of them are left out of the report")
+	bots := flag.String("bots", strings.Join(vcs.DefaultBots, ","), "Comma separated accounts whose comments are counted as bot comments. An entry starting with '*' matches the logins ending with the rest of it")
 	pr := flag.Int("pr", -1, "Single PR to query. If set 'to'/'from' are ignored and single PR is fetched.")
 	sfrom := flag.String("from", nlw.Format("2006-01-02"), "When the extraction starts")
 	sto := flag.String("to", today.Format("2006-01-02"), "When the extraction ends")
 	includeCreator := flag.Bool("include-creator", false, "If set, information about who created a PR is included")
 	csv := flag.Bool("csv", false, "If set, output export as csv")
 	json := flag.Bool("json", false, "If set, output export as json")
-	flag.Parse()
+	strict := flag.Bool("strict", false, "If set, exit with an error when any PR could not be fully fetched")
+	anchorAtReady := flag.Bool("anchor-ready", false, "If set, the review KPIs of draft PRs start when they were marked as ready for review instead of when they were opened")
+	args := os.Args[1:]
+	syncing := len(args) > 0 && args[0] == "sync"
+	if syncing {
+		args = args[1:]
+		if *storeDir == "" {
+			*storeDir = defaultStoreDir
+		}
+	}
+	flag.CommandLine.Parse(args)
 
 	if len(os.Args) < 2 {
 		printError("Invalid number of arguments")
 		os.Exit(1)
 	}
 
-	if *owner == "" {
+	if *owner == "" && *provider != "git" {
 		printError("Invalid owner")
 		os.Exit(1)
 	}
 
-	if *repo == "" {
+	if *repo == "" && *provider != "git" {
 		printError("Invalid repo")
 		os.Exit(1)
 	}
@@ -81,19 +148,113 @@ func main() {
 		os.Exit(2)
 	}
 
-	if config.Env.GitHubToken == "" {
-		fmt.Fprintf(os.Stderr, "Error: GITHUB_TOKEN environment variable not found. (You can use .env file to define it)")
+	bases := vcs.ParseBases(*base)
+	if len(bases) == 0 {
+		printError("Invalid base")
+		os.Exit(2)
+	}
+
+	if *checkRateLimit && *graphql {
+		printError("`check-rate-limit` can't be used with `graphql`")
+		os.Exit(2)
+	}
+
+	if *groupBy != "" && *groupBy != "base" && *groupBy != "label" {
+		printError("Invalid `group-by`, it must be 'base' or 'label'")
+		os.Exit(2)
+	}
+	report := reportOptions{
+		includeCreator: *includeCreator,
+		strict:         *strict,
+		kpi:            vcs.KPIOptions{AnchorAtReady: *anchorAtReady, Bots: vcs.ParseBots(*bots)},
+		groupBy:        *groupBy,
+		labels:         vcs.ParseLabels(*label),
+		excludeLabels:  vcs.ParseLabels(*excludeLabel),
+	}
+
+	opts := clientOptions{
+		provider:  *provider,
+		gitDir:    *gitDir,
+		graphql:   *graphql,
+		replaying: *replayDir != "",
+		github:    ghapi.Options{BaseURL: *githubURL, UploadURL: *githubUploadURL, Concurrency: *concurrency, CheckRateLimit: *checkRateLimit},
+	}
+	if err := setupReplay(&opts.github, *record, *replayDir); err != nil {
+		printError(err.Error())
 		os.Exit(3)
 	}
+	var retrier *retry.Transport
+	if *retries > 0 && *replayDir == "" {
+		retrier = retry.NewTransport(opts.github.Transport, *retries, retryWait)
+		opts.github.Transport = retrier
+	}
+	var cache *httpcache.Transport
+	if *cacheDir != "" {
+		cache, err = httpcache.NewTransport(*cacheDir, opts.github.Transport)
+		if err != nil {
+			printError(err.Error())
+			os.Exit(3)
+		}
+		opts.github.Transport = cache
+	}
 
-	renderers := setupRenderers(*csv, *json)
+	var prStore *store.Store
+	if *storeDir != "" {
+		prStore, err = store.Open(*storeDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s", err.Error())
+			os.Exit(3)
+		}
+	}
+
+	var vchClient vcs.Client
+	if prStore != nil && !syncing {
+		vchClient = store.NewClient(prStore, *provider)
+	} else {
+		vchClient, err = setupClient(opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s", err.Error())
+		os.Exit(3)
+	}
+
+	ctx := interruptibleContext()
+	if syncing {
+		prs, err := prStore.Sync(ctx, vchClient, *provider, *owner, *repo, bases, from)
+		logTransportStats(retrier, cache)
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Error: interrupted, %d PRs were stored but the sync is incomplete\n", len(prs))
+			os.Exit(5)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error syncing: %s\n", err.Error())
+			os.Exit(4)
+		}
+		log.Printf("Stored %d PRs in %s", len(prs), *storeDir)
+		if report.strict {
+			if err := checkWarnings(prs); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s, they are fetched again on the next sync\n", err.Error())
+				os.Exit(6)
+			}
+		}
+		os.Exit(0)
+	}
 
-	vchClient := ghapi.NewClient(config.Env.GitHubToken)
+	renderers := setupRenderers(*csv, *json)
 
 	if *pr > 0 {
-		err = getSingle(*vchClient, *owner, *repo, *pr, renderers)
+		err = getSingle(ctx, vchClient, *owner, *repo, *pr, report, renderers)
 	} else {
-		err = getAll(*vchClient, *owner, *repo, *base, from, to, *includeCreator, renderers)
+		err = getAll(ctx, vchClient, *owner, *repo, bases, from, to, report, renderers)
+	}
+	logTransportStats(retrier, cache)
+	if errors.Is(err, errPartial) {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(5)
+	}
+	if errors.Is(err, errWarnings) {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(6)
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error rendering: %s\n", err.Error())
@@ -102,6 +263,112 @@ func main() {
 	os.Exit(0)
 }
 
+func setupClient(opts clientOptions) (vcs.Client, error) {
+	switch opts.provider {
+	case "github":
+		if config.Env.GitHubAppID != 0 {
+			app, err := setupGitHubApp()
+			if err != nil {
+				return nil, err
+			}
+			opts.github.App = app
+		} else if config.Env.GitHubToken == "" && !opts.replaying {
+			return nil, fmt.Errorf("GITHUB_TOKEN environment variable not found. (You can use .env file to define it)")
+		}
+		if opts.graphql {
+			return ghapi.NewGraphQLClient(config.Env.GitHubToken, opts.github)
+		}
+		return ghapi.NewClient(config.Env.GitHubToken, opts.github)
+	case "gitlab":
+		if config.Env.GitLabToken == "" {
+			return nil, fmt.Errorf("GITLAB_TOKEN environment variable not found. (You can use .env file to define it)")
+		}
+		return glapi.NewClient(config.Env.GitLabURL, config.Env.GitLabToken), nil
+	case "bitbucket", "bitbucket-server":
+		if config.Env.BitbucketToken == "" {
+			return nil, fmt.Errorf("BITBUCKET_TOKEN environment variable not found. (You can use .env file to define it)")
+		}
+		if opts.provider == "bitbucket-server" {
+			return bbapi.NewServerClient(config.Env.BitbucketURL, config.Env.BitbucketUsername, config.Env.BitbucketToken, nil), nil
+		}
+		return bbapi.NewCloudClient(config.Env.BitbucketURL, config.Env.BitbucketUsername, config.Env.BitbucketToken, nil), nil
+	case "gitea":
+		if config.Env.GiteaToken == "" || config.Env.GiteaURL == "" {
+			return nil, fmt.Errorf("GITEA_TOKEN and GITEA_URL environment variables not found. (You can use .env file to define them)")
+		}
+		return gtapi.NewClient(config.Env.GiteaURL, config.Env.GiteaToken), nil
+	case "azure":
+		if config.Env.AzureDevOpsPAT == "" || config.Env.AzureDevOpsURL == "" {
+			return nil, fmt.Errorf("AZURE_DEVOPS_PAT and AZURE_DEVOPS_URL environment variables not found. (You can use .env file to define them)")
+		}
+		return azapi.NewClient(config.Env.AzureDevOpsURL, config.Env.AzureDevOpsPAT), nil
+	case "gerrit":
+		if config.Env.GerritURL == "" {
+			return nil, fmt.Errorf("GERRIT_URL environment variable not found. (You can use .env file to define it)")
+		}
+		return grapi.NewClient(config.Env.GerritURL, config.Env.GerritUsername, config.Env.GerritPassword), nil
+	case "git":
+		return gitlocal.NewClient(opts.gitDir), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", opts.provider)
+	}
+}
+
+// setupReplay sets the transport recording GitHub responses to, or replaying
+// them from, a directory.
+func setupReplay(ghOpts *ghapi.Options, recordDir, replayDir string) error {
+	var err error
+	switch {
+	case recordDir != "" && replayDir != "":
+		return fmt.Errorf("`record` and `replay` can't be used together")
+	case recordDir != "":
+		ghOpts.Transport, err = replay.NewRecorder(recordDir, nil)
+	case replayDir != "":
+		ghOpts.Transport, err = replay.NewReplayer(replayDir)
+	}
+	return err
+}
+
+func setupGitHubApp() (*ghapi.AppAuth, error) {
+	if config.Env.GitHubAppInstallationID == 0 || config.Env.GitHubAppPrivateKeyFile == "" {
+		return nil, fmt.Errorf("GITHUB_APP_INSTALLATION_ID and GITHUB_APP_PRIVATE_KEY_FILE environment variables are required with GITHUB_APP_ID")
+	}
+	key, err := ioutil.ReadFile(config.Env.GitHubAppPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key: %w", err)
+	}
+	return &ghapi.AppAuth{
+		AppID:          config.Env.GitHubAppID,
+		InstallationID: config.Env.GitHubAppInstallationID,
+		PrivateKey:     key,
+	}, nil
+}
+
+func logTransportStats(retrier *retry.Transport, cache *httpcache.Transport) {
+	if retrier != nil && retrier.Retries() > 0 {
+		log.Printf("%d GitHub requests were retried", retrier.Retries())
+	}
+	if cache != nil {
+		requests, hits := cache.Stats()
+		log.Printf("%d of %d GitHub requests were served from the cache", hits, requests)
+	}
+}
+
+// interruptibleContext returns a context cancelled on SIGINT or SIGTERM, so
+// that a run can be stopped while still reporting what was fetched.
+func interruptibleContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Received %s, stopping and reporting the PRs fetched so far", sig)
+		signal.Stop(sigs) // a second signal kills the process
+		cancel()
+	}()
+	return ctx
+}
+
 func setupRenderers(renderCSV, renderJSON bool) []renderer {
 	var renderers = []renderer{
 		{
@@ -113,43 +380,81 @@ func setupRenderers(renderCSV, renderJSON bool) []renderer {
 		renderers = append(renderers,
 			renderer{
 				csv.RenderSingle,
-				csv.Render,
+				ungrouped(csv.Render),
 			})
 	}
 	if renderJSON {
 		renderers = append(renderers,
 			renderer{
 				json.RenderSingle,
-				json.Render,
+				ungrouped(json.Render),
 			})
 	}
 	return renderers
 }
 
-func getAll(client ghapi.Client, owner, repo, base string, from, to time.Time, includeCreator bool, renderers []renderer) error {
-	prs, err := client.GetMergedPRList(owner, repo, from, to, base)
-	if err != nil {
+// ungrouped adapts the render function of an export, which lists every PR
+// once whatever the grouping of the report.
+func ungrouped(render func(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, opts vcs.KPIOptions) error) func(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, groupBy string, opts vcs.KPIOptions) error {
+	return func(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, groupBy string, opts vcs.KPIOptions) error {
+		return render(prs, owner, repo, from, to, includeCreator, partial, opts)
+	}
+}
+
+var (
+	errPartial  = errors.New("interrupted, the report only includes the PRs fetched so far")
+	errWarnings = errors.New("some PRs could not be fully fetched")
+)
+
+// checkWarnings fails strict runs when any PR has warnings.
+func checkWarnings(prs []vcs.PR) error {
+	n := 0
+	for _, pr := range prs {
+		if len(pr.Warnings) > 0 {
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: %d PRs have warnings", errWarnings, n)
+	}
+	return nil
+}
+
+func getAll(ctx context.Context, client vcs.Client, owner, repo string, bases []string, from, to time.Time, report reportOptions, renderers []renderer) error {
+	prs, err := client.GetMergedPRList(ctx, owner, repo, from, to, bases)
+	partial := err != nil && ctx.Err() != nil
+	if err != nil && !partial {
 		return err
 	}
+	prs = vcs.FilterLabels(prs, report.labels, report.excludeLabels)
 	for _, r := range renderers {
-		err = r.render(prs, owner, repo, from, to, includeCreator)
+		err = r.render(prs, owner, repo, from, to, report.includeCreator, partial, report.groupBy, report.kpi)
 		if err != nil {
 			return err
 		}
 	}
+	if partial {
+		return errPartial
+	}
+	if report.strict {
+		return checkWarnings(prs)
+	}
 	return nil
 }
 
-func getSingle(client ghapi.Client, owner, repo string, prNum int, renderers []renderer) error {
-	pr, err := client.GetPRInfo(owner, repo, prNum)
+func getSingle(ctx context.Context, client vcs.Client, owner, repo string, prNum int, report reportOptions, renderers []renderer) error {
+	pr, err := client.GetPRInfo(ctx, owner, repo, prNum)
 	if err != nil {
 		return err
 	}
 	for _, r := range renderers {
-		err = r.renderSingle(pr)
+		err = r.renderSingle(pr, report.kpi)
 		if err != nil {
 			return err
 		}
 	}
+	if report.strict {
+		return checkWarnings([]vcs.PR{pr})
+	}
 	return nil
 }
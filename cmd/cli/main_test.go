@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden reports in testdata")
+
+// TestReplayReport renders every report of the GitHub responses recorded in
+// testdata/replay and compares them with the golden ones in testdata.
+func TestReplayReport(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := clientOptions{provider: "github", replaying: true}
+	if err := setupReplay(&opts.github, "", filepath.Join(testdata, "replay")); err != nil {
+		t.Fatal(err)
+	}
+	client, err := setupClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the renderers write their reports to the working directory and stdout
+	dir, err := ioutil.TempDir("", "mkpis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	stdout, err := os.Create(filepath.Join(dir, "pr_report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	os.Stdout, stdout = stdout, os.Stdout
+	defer func() { os.Stdout = stdout }()
+
+	from := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 8, 31, 0, 0, 0, 0, time.UTC)
+	report := reportOptions{includeCreator: true, kpi: vcs.KPIOptions{Bots: vcs.DefaultBots}}
+	err = getAll(context.Background(), client, "owner", "repo", []string{"master"}, from, to, report, setupRenderers(true, true))
+	os.Stdout, stdout = stdout, os.Stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"pr_report.txt", "pr_report.csv", "pr_report.json"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join(testdata, name)
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s differs from %s:\n%s", name, golden, got)
+		}
+	}
+}
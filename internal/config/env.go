@@ -8,7 +8,30 @@ import (
 )
 
 type configuration struct {
-	GitHubToken string `env:"GITHUB_TOKEN"`
+	GitHubToken     string `env:"GITHUB_TOKEN"`
+	GitHubURL       string `env:"GITHUB_API_URL"`
+	GitHubUploadURL string `env:"GITHUB_UPLOAD_URL"`
+
+	GitHubAppID             int64  `env:"GITHUB_APP_ID"`
+	GitHubAppInstallationID int64  `env:"GITHUB_APP_INSTALLATION_ID"`
+	GitHubAppPrivateKeyFile string `env:"GITHUB_APP_PRIVATE_KEY_FILE"`
+
+	GitLabToken string `env:"GITLAB_TOKEN"`
+	GitLabURL   string `env:"GITLAB_URL" envDefault:"https://gitlab.com"`
+
+	BitbucketUsername string `env:"BITBUCKET_USERNAME"`
+	BitbucketToken    string `env:"BITBUCKET_TOKEN"`
+	BitbucketURL      string `env:"BITBUCKET_URL" envDefault:"https://api.bitbucket.org"`
+
+	GiteaToken string `env:"GITEA_TOKEN"`
+	GiteaURL   string `env:"GITEA_URL"`
+
+	AzureDevOpsPAT string `env:"AZURE_DEVOPS_PAT"`
+	AzureDevOpsURL string `env:"AZURE_DEVOPS_URL"`
+
+	GerritURL      string `env:"GERRIT_URL"`
+	GerritUsername string `env:"GERRIT_USERNAME"`
+	GerritPassword string `env:"GERRIT_PASSWORD"`
 }
 
 func loadConfig() *configuration {
@@ -5,19 +5,26 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/davidscholberg/go-durationfmt"
 	"github.com/jmartin82/mkpis/pkg/vcs"
 )
 
-func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator bool) error {
-	f, err := os.Create("pr_report.csv")
+// Render writes the report to pr_report.csv, or to pr_report.partial.csv when
+// the run was interrupted before all the PRs were fetched.
+func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, opts vcs.KPIOptions) error {
+	name := "pr_report.csv"
+	if partial {
+		name = "pr_report.partial.csv"
+	}
+	f, err := os.Create(name)
 	if err != nil {
 		return err
 	}
 	w := csv.NewWriter(f)
-	header := []string{"Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Comments", "PR Lead Time", "Time To Merge"}
+	header := []string{"Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Comments", "PR Lead Time", "Time To Merge", "Time To First Approval", "Approval To Merge", "Approvals", "Changes Requested", "Review Request Latency", "Time In Draft", "Labels", "Milestone", "Warnings", "Base", "Conversation Comments", "Bot Comments"}
 	err = w.Write(header)
 	if err != nil {
 		return err
@@ -25,13 +32,25 @@ func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator
 	for _, pr := range prs {
 		err = w.Write([]string{
 			strconv.Itoa(pr.Commits),
-			strconv.Itoa(pr.ChangedLines),
-			DurationFormater(pr.TimeToFirstReview()),
+			SizeFormater(pr),
+			DurationFormater(pr.TimeToFirstReview(opts)),
 			DurationFormater(pr.TimeToReview()),
 			DurationFormater(pr.LastReviewToMerge()),
-			strconv.Itoa(pr.ReviewComments),
-			DurationFormater(pr.PRLeadTime()),
+			strconv.Itoa(pr.HumanReviewComments(opts)),
+			DurationFormater(pr.PRLeadTime(opts)),
 			DurationFormater(pr.TimeToMerge()),
+			DurationFormater(pr.TimeToFirstApproval(opts)),
+			DurationFormater(pr.ApprovalToMerge()),
+			strconv.Itoa(pr.ApprovalsCount()),
+			strconv.Itoa(pr.ChangesRequestedCount()),
+			DurationFormater(pr.ReviewRequestLatency()),
+			DurationFormater(pr.TimeInDraft()),
+			strings.Join(pr.Labels, "; "),
+			pr.Milestone,
+			strings.Join(pr.Warnings, "; "),
+			pr.Base,
+			CommentsFormater(pr, pr.ConversationComments(opts)),
+			CommentsFormater(pr, pr.BotComments(opts)),
 		})
 		if err != nil {
 			return err
@@ -42,6 +61,23 @@ func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator
 	return nil
 }
 
+// SizeFormater formats the size of a PR in lines, left empty when unknown.
+func SizeFormater(pr vcs.PR) string {
+	if pr.SizeUnknown {
+		return ""
+	}
+	return strconv.Itoa(pr.ChangedLines)
+}
+
+// CommentsFormater formats a count of the listed comments of a PR, left
+// empty when unknown.
+func CommentsFormater(pr vcs.PR, count int) string {
+	if pr.CommentsUnknown {
+		return ""
+	}
+	return strconv.Itoa(count)
+}
+
 func DurationFormater(d time.Duration) string {
 
 	if d.Microseconds() == 0 {
@@ -55,13 +91,13 @@ func DurationFormater(d time.Duration) string {
 	return t
 }
 
-func RenderSingle(pr vcs.PR) error {
+func RenderSingle(pr vcs.PR, opts vcs.KPIOptions) error {
 	f, err := os.Create(fmt.Sprintf("pr_%d.csv", pr.Number))
 	if err != nil {
 		return err
 	}
 	w := csv.NewWriter(f)
-	header := []string{"Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Comments", "PR Lead Time", "Time To Merge"}
+	header := []string{"Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Comments", "PR Lead Time", "Time To Merge", "Time To First Approval", "Approval To Merge", "Approvals", "Changes Requested", "Review Request Latency", "Time In Draft", "Labels", "Milestone", "Warnings", "Base", "Conversation Comments", "Bot Comments"}
 	err = w.Write(header)
 	if err != nil {
 		return err
@@ -69,13 +105,25 @@ func RenderSingle(pr vcs.PR) error {
 
 	err = w.Write([]string{
 		strconv.Itoa(pr.Commits),
-		strconv.Itoa(pr.ChangedLines),
-		DurationFormater(pr.TimeToFirstReview()),
+		SizeFormater(pr),
+		DurationFormater(pr.TimeToFirstReview(opts)),
 		DurationFormater(pr.TimeToReview()),
 		DurationFormater(pr.LastReviewToMerge()),
-		strconv.Itoa(pr.ReviewComments),
-		DurationFormater(pr.PRLeadTime()),
+		strconv.Itoa(pr.HumanReviewComments(opts)),
+		DurationFormater(pr.PRLeadTime(opts)),
 		DurationFormater(pr.TimeToMerge()),
+		DurationFormater(pr.TimeToFirstApproval(opts)),
+		DurationFormater(pr.ApprovalToMerge()),
+		strconv.Itoa(pr.ApprovalsCount()),
+		strconv.Itoa(pr.ChangesRequestedCount()),
+		DurationFormater(pr.ReviewRequestLatency()),
+		DurationFormater(pr.TimeInDraft()),
+		strings.Join(pr.Labels, "; "),
+		pr.Milestone,
+		strings.Join(pr.Warnings, "; "),
+		pr.Base,
+		CommentsFormater(pr, pr.ConversationComments(opts)),
+		CommentsFormater(pr, pr.BotComments(opts)),
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,162 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// entry is a cached response, stored as one JSON file per request.
+type entry struct {
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// Transport is an http.RoundTripper that keeps the GET responses carrying an
+// ETag or Last-Modified header in a directory, and revalidates them with
+// conditional requests. A 304 Not Modified answer is served from the cache;
+// GitHub does not count those against the rate limit.
+//
+// Requests are keyed by URL, Accept header and a hash of the Authorization
+// header, so that a directory shared between tokens never serves the private
+// responses of one to another. The credentials themselves are not stored.
+type Transport struct {
+	dir      string
+	next     http.RoundTripper
+	requests int64
+	hits     int64
+}
+
+// NewTransport returns a Transport sending requests through next (or
+// http.DefaultTransport when nil) and caching the responses in dir.
+func NewTransport(dir string, next http.RoundTripper) (*Transport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{dir: dir, next: next}, nil
+}
+
+// Stats returns the number of requests sent and how many of them were
+// served from the cache.
+func (t *Transport) Stats() (requests int64, hits int64) {
+	return atomic.LoadInt64(&t.requests), atomic.LoadInt64(&t.hits)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+	atomic.AddInt64(&t.requests, 1)
+	path := filepath.Join(t.dir, key(req)+".json")
+
+	cached := t.load(path)
+	if cached != nil {
+		// RoundTrippers must not modify the request
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		atomic.AddInt64(&t.hits, 1)
+		// the fresh headers carry the current rate limit
+		for k, v := range resp.Header {
+			if k != "Content-Length" {
+				cached.Header[k] = v
+			}
+		}
+		return cached.response(req), nil
+	}
+	if resp.StatusCode != http.StatusOK || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+	return t.store(path, req, resp)
+}
+
+// key returns the cache key of req. The Authorization header is hashed along
+// with the rest, so that it can't be read back from the file names.
+func key(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Accept") + "\n" + req.Header.Get("Authorization")))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (t *Transport) load(path string) *entry {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil || e.Header == nil {
+		return nil
+	}
+	return &e
+}
+
+func (t *Transport) store(path string, req *http.Request, resp *http.Response) (*http.Response, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	data, err := json.Marshal(entry{
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   string(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// concurrent requests for the same URL must not interleave their writes
+	f, err := ioutil.TempFile(t.dir, "entry")
+	if err == nil {
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache %s: %w", req.URL, err)
+	}
+	return resp, nil
+}
+
+func (e *entry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
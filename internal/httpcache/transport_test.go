@@ -0,0 +1,145 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// server answers with an ETag and a Last-Modified date, and with 304 Not
+// Modified to the requests revalidating them. It records the conditional
+// headers of every request.
+type server struct {
+	mu         sync.Mutex
+	status     int
+	body       string
+	conditions []string
+}
+
+const (
+	etag         = `"v1"`
+	lastModified = "Sat, 01 Aug 2020 00:00:00 GMT"
+)
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditions = append(s.conditions, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified)
+	w.Header().Set("X-RateLimit-Remaining", "4999")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+	}
+	w.Write([]byte(s.body + " for " + r.Header.Get("Authorization")))
+}
+
+func newCache(t *testing.T) (*Transport, string) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	tr, err := NewTransport(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, dir
+}
+
+func get(t *testing.T, tr http.RoundTripper, u, auth string) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", auth)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func entries(t *testing.T, dir string) int {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestRevalidate(t *testing.T) {
+	srv := &server{body: "repo"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	tr, dir := newCache(t)
+
+	if status, body := get(t, tr, ts.URL, "token a"); status != http.StatusOK || body != "repo for token a" {
+		t.Fatalf("got %d %q, want 200 from the server", status, body)
+	}
+	if n := entries(t, dir); n != 1 {
+		t.Fatalf("cached %d responses, want 1", n)
+	}
+	if status, body := get(t, tr, ts.URL, "token a"); status != http.StatusOK || body != "repo for token a" {
+		t.Errorf("got %d %q, want the cached 200", status, body)
+	}
+	want := []string{"|", etag + "|" + lastModified}
+	if len(srv.conditions) != 2 || srv.conditions[0] != want[0] || srv.conditions[1] != want[1] {
+		t.Errorf("sent conditions %q, want %q", srv.conditions, want)
+	}
+	if requests, hits := tr.Stats(); requests != 2 || hits != 1 {
+		t.Errorf("stats = %d requests, %d hits, want 2 and 1", requests, hits)
+	}
+}
+
+func TestKeyedByCredentials(t *testing.T) {
+	srv := &server{body: "private repo"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	tr, dir := newCache(t)
+
+	get(t, tr, ts.URL, "token a")
+	if status, body := get(t, tr, ts.URL, "token b"); status != http.StatusOK || body != "private repo for token b" {
+		t.Errorf("got %d %q for token b, want its own response", status, body)
+	}
+	if srv.conditions[1] != "|" {
+		t.Errorf("revalidated the response of token a for token b")
+	}
+	if n := entries(t, dir); n != 2 {
+		t.Errorf("cached %d responses, want one per token", n)
+	}
+	if _, hits := tr.Stats(); hits != 0 {
+		t.Errorf("%d hits, want none", hits)
+	}
+}
+
+func TestSkipErrors(t *testing.T) {
+	srv := &server{status: http.StatusNotFound, body: "not found"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	tr, dir := newCache(t)
+
+	if status, _ := get(t, tr, ts.URL, "token a"); status != http.StatusNotFound {
+		t.Fatalf("got %d, want 404", status)
+	}
+	if n := entries(t, dir); n != 0 {
+		t.Errorf("cached %d error responses", n)
+	}
+	get(t, tr, ts.URL, "token a")
+	if srv.conditions[1] != "|" {
+		t.Errorf("revalidated an error response")
+	}
+}
@@ -12,21 +12,47 @@ import (
 )
 
 type PRList struct {
-	PRs []PR `json:"prs"`
+	// Partial is set when the run was interrupted before all the PRs were
+	// fetched.
+	Partial bool `json:"partial,omitempty"`
+	PRs     []PR `json:"prs"`
+	// Reviewers is the review request latency of every reviewer, slowest
+	// first.
+	Reviewers []Reviewer `json:"reviewers,omitempty"`
+}
+
+type Reviewer struct {
+	Reviewer      string `json:"reviewer"`
+	Answered      int    `json:"answered"`
+	AvgLatency    string `json:"avgLatency"`
+	MedianLatency string `json:"medianLatency"`
 }
 
 type PR struct {
-	Commits           int    `json:"commits"`
-	Size              int    `json:"size"`
-	TimeToFirstReview string `json:"timeToFirstReview"`
-	ReviewTime        string `json:"reviewTime"`
-	LastReviewToMerge string `json:"lastReviewToMerge"`
-	Comments          int    `json:"comments"`
-	PRLeadTime        string `json:"prLeadTime"`
-	TimeToMerge       string `json:"timeToMerge"`
+	Base                 string   `json:"base"`
+	Commits              int      `json:"commits"`
+	Size                 *int     `json:"size"`
+	TimeToFirstReview    string   `json:"timeToFirstReview"`
+	ReviewTime           string   `json:"reviewTime"`
+	LastReviewToMerge    string   `json:"lastReviewToMerge"`
+	Comments             int      `json:"comments"`
+	ConversationComments *int     `json:"conversationComments"`
+	BotComments          *int     `json:"botComments"`
+	PRLeadTime           string   `json:"prLeadTime"`
+	TimeToMerge          string   `json:"timeToMerge"`
+	TimeToFirstApproval  string   `json:"timeToFirstApproval"`
+	ApprovalToMerge      string   `json:"approvalToMerge"`
+	Approvals            int      `json:"approvals"`
+	ChangesRequested     int      `json:"changesRequested"`
+	ReviewRequestLatency string   `json:"reviewRequestLatency"`
+	Draft                bool     `json:"draft"`
+	TimeInDraft          string   `json:"timeInDraft"`
+	Labels               []string `json:"labels,omitempty"`
+	Milestone            string   `json:"milestone,omitempty"`
+	Warnings             []string `json:"warnings,omitempty"`
 }
 
-func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator bool) error {
+func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, opts vcs.KPIOptions) error {
 	f, err := os.Create("pr_report.json")
 	if err != nil {
 		return err
@@ -37,18 +63,36 @@ func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator
 
 	for i, pr := range prs {
 		jsonPRs[i] = PR{
+			pr.Base,
 			pr.Commits,
-			pr.ChangedLines,
-			DurationFormater(pr.TimeToFirstReview()),
+			size(pr),
+			DurationFormater(pr.TimeToFirstReview(opts)),
 			DurationFormater(pr.TimeToReview()),
 			DurationFormater(pr.LastReviewToMerge()),
-			pr.ReviewComments,
-			DurationFormater(pr.PRLeadTime()),
+			pr.HumanReviewComments(opts),
+			comments(pr, pr.ConversationComments(opts)),
+			comments(pr, pr.BotComments(opts)),
+			DurationFormater(pr.PRLeadTime(opts)),
 			DurationFormater(pr.TimeToMerge()),
+			DurationFormater(pr.TimeToFirstApproval(opts)),
+			DurationFormater(pr.ApprovalToMerge()),
+			pr.ApprovalsCount(),
+			pr.ChangesRequestedCount(),
+			DurationFormater(pr.ReviewRequestLatency()),
+			pr.Draft,
+			DurationFormater(pr.TimeInDraft()),
+			pr.Labels,
+			pr.Milestone,
+			pr.Warnings,
 		}
 	}
 
-	b, err := json.MarshalIndent(PRList{jsonPRs}, "", "  ")
+	var reviewers []Reviewer
+	for _, l := range vcs.NewKPICalculator(prs, opts).ReviewerLatencies() {
+		reviewers = append(reviewers, Reviewer{l.Reviewer, l.Answered, DurationFormater(l.Avg), DurationFormater(l.Median)})
+	}
+
+	b, err := json.MarshalIndent(PRList{partial, jsonPRs, reviewers}, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -58,6 +102,23 @@ func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator
 	return nil
 }
 
+// size returns the size of a PR in lines, or nil when unknown.
+func size(pr vcs.PR) *int {
+	if pr.SizeUnknown {
+		return nil
+	}
+	return &pr.ChangedLines
+}
+
+// comments returns a count of the listed comments of a PR, or nil when
+// unknown.
+func comments(pr vcs.PR, count int) *int {
+	if pr.CommentsUnknown {
+		return nil
+	}
+	return &count
+}
+
 func DurationFormater(d time.Duration) string {
 
 	if d.Microseconds() == 0 {
@@ -71,7 +132,7 @@ func DurationFormater(d time.Duration) string {
 	return t
 }
 
-func RenderSingle(pr vcs.PR) error {
+func RenderSingle(pr vcs.PR, opts vcs.KPIOptions) error {
 	f, err := os.Create(fmt.Sprintf("pr_%d.json", pr.Number))
 	if err != nil {
 		return err
@@ -79,14 +140,27 @@ func RenderSingle(pr vcs.PR) error {
 	w := bufio.NewWriter(f)
 
 	jsonPR := PR{
+		pr.Base,
 		pr.Commits,
-		pr.ChangedLines,
-		DurationFormater(pr.TimeToFirstReview()),
+		size(pr),
+		DurationFormater(pr.TimeToFirstReview(opts)),
 		DurationFormater(pr.TimeToReview()),
 		DurationFormater(pr.LastReviewToMerge()),
-		pr.ReviewComments,
-		DurationFormater(pr.PRLeadTime()),
+		pr.HumanReviewComments(opts),
+		comments(pr, pr.ConversationComments(opts)),
+		comments(pr, pr.BotComments(opts)),
+		DurationFormater(pr.PRLeadTime(opts)),
 		DurationFormater(pr.TimeToMerge()),
+		DurationFormater(pr.TimeToFirstApproval(opts)),
+		DurationFormater(pr.ApprovalToMerge()),
+		pr.ApprovalsCount(),
+		pr.ChangesRequestedCount(),
+		DurationFormater(pr.ReviewRequestLatency()),
+		pr.Draft,
+		DurationFormater(pr.TimeInDraft()),
+		pr.Labels,
+		pr.Milestone,
+		pr.Warnings,
 	}
 
 	b, err := json.MarshalIndent(jsonPR, "", "  ")
@@ -0,0 +1,160 @@
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// cassette is a recorded HTTP exchange, stored as one JSON file per request.
+type cassette struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// Transport is an http.RoundTripper that either records the responses of
+// the wrapped transport into a directory of cassettes, or serves requests
+// from those cassettes without touching the network.
+//
+// Requests are matched by method, URL and body. Request headers, including
+// the credentials, are neither matched nor recorded, and the secret fields
+// of the responses, such as the token minted for a GitHub App installation,
+// are recorded redacted.
+type Transport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+// secretFields are the top level fields of the JSON responses that hold
+// credentials.
+var secretFields = []string{"token"}
+
+// redacted replaces the value of the secret fields in the recordings.
+const redacted = "REDACTED"
+
+// redact returns body with the secret fields of a JSON object replaced.
+// Other bodies are returned unchanged.
+func redact(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	found := false
+	for _, name := range secretFields {
+		if _, ok := fields[name]; ok {
+			fields[name] = json.RawMessage(`"` + redacted + `"`)
+			found = true
+		}
+	}
+	if !found {
+		return body
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// NewRecorder returns a Transport sending requests through next (or
+// http.DefaultTransport when nil) and saving every response to dir.
+func NewRecorder(dir string, next http.RoundTripper) (*Transport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{dir: dir, next: next}, nil
+}
+
+// NewReplayer returns a Transport answering requests with the responses
+// recorded in dir.
+func NewReplayer(dir string) (*Transport, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &Transport{dir: dir}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	sum := sha256.Sum256(append([]byte(req.Method+" "+req.URL.String()+"\n"), body...))
+	path := filepath.Join(t.dir, hex.EncodeToString(sum[:16])+".json")
+
+	if t.next == nil {
+		return t.replay(req, path)
+	}
+	return t.record(req, path)
+}
+
+func (t *Transport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *Transport) record(req *http.Request, path string) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	data, err := json.MarshalIndent(cassette{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   string(redact(body)),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to record %s %s: %w", req.Method, req.URL, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,105 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Transport is an http.RoundTripper retrying the requests that failed with
+// a transient error: a 5xx response, a timeout or a connection reset. The
+// wait between attempts doubles every time, with a random jitter so that
+// concurrent requests don't retry in lockstep.
+type Transport struct {
+	next       http.RoundTripper
+	maxRetries int
+	baseWait   time.Duration
+	retries    int64
+}
+
+// NewTransport returns a Transport sending requests through next (or
+// http.DefaultTransport when nil) up to maxRetries more times, waiting
+// baseWait before the first retry.
+func NewTransport(next http.RoundTripper, maxRetries int, baseWait time.Duration) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{next: next, maxRetries: maxRetries, baseWait: baseWait}
+}
+
+// Retries returns the number of retries made so far.
+func (t *Transport) Retries() int64 {
+	return atomic.LoadInt64(&t.retries)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("can't retry %s %s: body can't be rewound", req.Method, req.URL)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		reason := transient(resp, err)
+		if reason == "" || attempt >= t.maxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		wait := t.wait(attempt)
+		atomic.AddInt64(&t.retries, 1)
+		log.Printf("Retrying %s %s in %s (retry %d of %d): %s", req.Method, req.URL.Path, wait.Round(time.Millisecond), attempt+1, t.maxRetries, reason)
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// wait returns the backoff before a retry: baseWait doubled on every
+// attempt, of which a random half is jitter.
+func (t *Transport) wait(attempt int) time.Duration {
+	d := t.baseWait << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// transient describes why a response or error is worth retrying, or
+// returns an empty string when it is not.
+func transient(resp *http.Response, err error) string {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return "timeout"
+		case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+			return "connection reset"
+		}
+		return ""
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// baseWait keeps the backoff of the tests short.
+const baseWait = time.Millisecond
+
+// failing returns a server answering with status the first failures
+// requests, then with 200, and its request counter.
+func failing(status, failures int) (*httptest.Server, *int64) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= int64(failures) {
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	return srv, &requests
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		srv, requests := failing(status, 2)
+		tr := NewTransport(nil, 3, baseWait)
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%d: status = %d, want 200", status, resp.StatusCode)
+		}
+		if tr.Retries() != 2 || *requests != 3 {
+			t.Errorf("%d: %d retries in %d requests, want 2 in 3", status, tr.Retries(), *requests)
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv, requests := failing(http.StatusBadGateway, 10)
+	defer srv.Close()
+	tr := NewTransport(nil, 3, baseWait)
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || tr.Retries() != 3 || *requests != 4 {
+		t.Errorf("status %d after %d retries in %d requests, want 502 after 3 in 4", resp.StatusCode, tr.Retries(), *requests)
+	}
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	srv, requests := failing(http.StatusNotFound, 1)
+	defer srv.Close()
+	tr := NewTransport(nil, 3, baseWait)
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || tr.Retries() != 0 || *requests != 1 {
+		t.Errorf("status %d after %d retries in %d requests, want 404 without retries", resp.StatusCode, tr.Retries(), *requests)
+	}
+}
+
+func TestRetryDroppedConnections(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= 2 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	tr := NewTransport(nil, 3, baseWait)
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || tr.Retries() != 2 {
+		t.Errorf("status %d after %d retries, want 200 after 2", resp.StatusCode, tr.Retries())
+	}
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	srv, _ := failing(http.StatusServiceUnavailable, 1)
+	defer srv.Close()
+	tr := NewTransport(nil, 3, baseWait)
+	resp, err := (&http.Client{Transport: tr}).Post(srv.URL, "application/json", bytes.NewBufferString(`{"query":"q"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Retries() != 1 || string(body) != `{"query":"q"}` {
+		t.Errorf("retried %d times and sent %q, want the body sent again once", tr.Retries(), body)
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	srv, requests := failing(http.StatusBadGateway, 10)
+	defer srv.Close()
+	// the request is cancelled while waiting for its first retry
+	tr := NewTransport(nil, 3, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := tr.RoundTrip(req); err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("stopped after %s", elapsed)
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, want 1", *requests)
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// Client implements vcs.Client on top of the PRs kept in a Store, without
+// any network access.
+type Client struct {
+	s        *Store
+	provider string
+}
+
+func NewClient(s *Store, provider string) *Client {
+	return &Client{s: s, provider: provider}
+}
+
+// GetMergedPRList returns the stored PRs merged into bases between from and
+// to, most recently merged first.
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	r, err := cli.s.Load(cli.provider, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	span, missing, ok := r.syncedSpan(bases)
+	if !ok {
+		return nil, fmt.Errorf("%q of %s/%s was never synced, run `mkpis sync` first", missing, owner, repo)
+	}
+	if from.Before(span.Start) {
+		log.Printf("Warning: the store starts at %s, PRs merged earlier are not in the report; run `mkpis sync -from %s` to add them",
+			span.Start.Format(time.RFC3339), from.Format("2006-01-02"))
+	}
+	if to.After(span.End) {
+		log.Printf("Last sync was at %s, PRs merged later are not in the report", span.End.Format(time.RFC3339))
+	}
+	var pRList []vcs.PR
+	for _, pr := range r.PRs {
+		if !vcs.MatchBase(bases, pr.Base) || pr.MergedAt.Before(from) || pr.MergedAt.After(to) {
+			continue
+		}
+		pRList = append(pRList, pr)
+	}
+	sort.Slice(pRList, func(i, j int) bool {
+		return pRList[i].MergedAt.After(pRList[j].MergedAt)
+	})
+	return pRList, nil
+}
+
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	r, err := cli.s.Load(cli.provider, owner, repo)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	pr, ok := r.PRs[prNum]
+	if !ok {
+		return vcs.PR{}, fmt.Errorf("PR %d is not in the store, run `mkpis sync` first", prNum)
+	}
+	return pr, nil
+}
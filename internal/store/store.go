@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// Span is the range of merge times covered by the syncs of a list of base
+// branches.
+type Span struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Repo is the stored data of a repository: its merged PRs by number and the
+// span synced for each list of base branches.
+type Repo struct {
+	Synced map[string]Span `json:"synced"`
+	PRs    map[int]vcs.PR  `json:"prs"`
+}
+
+// Store keeps the fetched PRs on disk, as one JSON file per
+// provider/owner/repo, so that reports can be computed without calling the
+// provider again. Merged PRs don't change, so they are only fetched once.
+type Store struct {
+	dir string
+}
+
+// Open returns the Store kept in dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(provider, owner, repo string) string {
+	key := func(v string) string {
+		if v == "" {
+			return "_"
+		}
+		return url.PathEscape(v)
+	}
+	return filepath.Join(s.dir, key(provider), key(owner), key(repo)+".json")
+}
+
+// Load returns the stored data of a repository, which is empty when it was
+// never synced.
+func (s *Store) Load(provider, owner, repo string) (*Repo, error) {
+	r := &Repo{Synced: map[string]Span{}, PRs: map[int]vcs.PR{}}
+	data, err := ioutil.ReadFile(s.path(provider, owner, repo))
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to decode store of %s/%s: %w", owner, repo, err)
+	}
+	return r, nil
+}
+
+// Save writes the data of a repository. The file is replaced atomically so
+// that an interrupted sync never leaves a truncated store behind.
+func (s *Store) Save(provider, owner, repo string, r *Repo) error {
+	path := s.path(provider, owner, repo)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Sync fetches the PRs merged into bases since their last sync, or since
+// from when they were never synced, and adds them to the store. When from
+// is before the start of the synced span, the PRs merged in between are
+// fetched too. The stored PRs with warnings, which could not be fully
+// fetched, are fetched again. It returns the PRs fetched.
+//
+// When the sync fails or ctx is cancelled, the PRs fetched so far are saved
+// but the synced span only grows by the windows fully fetched, so that the
+// next sync fetches the rest again.
+func (s *Store) Sync(ctx context.Context, client vcs.Client, provider, owner, repo string, bases []string, from time.Time) ([]vcs.PR, error) {
+	r, err := s.Load(provider, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	key := basesKey(bases)
+	now := time.Now()
+	span, synced := r.Synced[key]
+	windows := []Span{{from, now}}
+	if synced {
+		windows = []Span{{span.End, now}}
+		if from.Before(span.Start) {
+			// backfill the PRs merged before the first sync
+			windows = []Span{{from, span.Start}, windows[0]}
+		}
+	}
+
+	var fetched []vcs.PR
+	for _, pr := range r.PRs {
+		if len(pr.Warnings) == 0 || !vcs.MatchBase(bases, pr.Base) {
+			continue
+		}
+		log.Printf("Fetching again PR %d, stored with warnings", pr.Number)
+		if pr, err = client.GetPRInfo(ctx, owner, repo, pr.Number); err != nil {
+			break
+		}
+		r.PRs[pr.Number] = pr
+		fetched = append(fetched, pr)
+	}
+
+	for _, w := range windows {
+		if err != nil {
+			break
+		}
+		log.Printf("Syncing PRs merged into %s from %s to %s", key, w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+		var prs []vcs.PR
+		prs, err = client.GetMergedPRList(ctx, owner, repo, w.Start, w.End, bases)
+		for _, pr := range prs {
+			r.PRs[pr.Number] = pr
+		}
+		fetched = append(fetched, prs...)
+		if err != nil {
+			break
+		}
+		if !synced {
+			span, synced = w, true
+		}
+		if w.Start.Before(span.Start) {
+			span.Start = w.Start
+		}
+		if w.End.After(span.End) {
+			span.End = w.End
+		}
+		r.Synced[key] = span
+	}
+	if err := s.Save(provider, owner, repo, r); err != nil {
+		return fetched, fmt.Errorf("failed to save store: %w", err)
+	}
+	return fetched, err
+}
+
+// basesKey identifies a list of bases in Repo.Synced, whatever their order.
+func basesKey(bases []string) string {
+	sorted := append([]string(nil), bases...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// syncedSpan returns the span synced for every base of bases. A sync covers
+// each branch matched by its bases, and a base synced several times is
+// covered by its latest sync. It returns the first base never synced when
+// there is one.
+func (r *Repo) syncedSpan(bases []string) (Span, string, bool) {
+	var covered Span
+	for i, base := range bases {
+		var latest Span
+		found := false
+		for key, span := range r.Synced {
+			if !vcs.MatchBase(strings.Split(key, ","), base) {
+				continue
+			}
+			if !found || span.End.After(latest.End) {
+				latest, found = span, true
+			}
+		}
+		if !found {
+			return Span{}, base, false
+		}
+		if i == 0 || latest.Start.After(covered.Start) {
+			covered.Start = latest.Start
+		}
+		if i == 0 || latest.End.Before(covered.End) {
+			covered.End = latest.End
+		}
+	}
+	return covered, "", true
+}
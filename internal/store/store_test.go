@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// fakeClient serves prs and records the windows it was asked to list.
+type fakeClient struct {
+	prs     []vcs.PR
+	windows []Span
+}
+
+func (c *fakeClient) GetMergedPRList(ctx context.Context, owner, repo string, from, to time.Time, bases []string) ([]vcs.PR, error) {
+	c.windows = append(c.windows, Span{from, to})
+	var prs []vcs.PR
+	for _, pr := range c.prs {
+		if vcs.MatchBase(bases, pr.Base) && !pr.MergedAt.Before(from) && !pr.MergedAt.After(to) {
+			prs = append(prs, pr)
+		}
+	}
+	return prs, nil
+}
+
+func (c *fakeClient) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	for _, pr := range c.prs {
+		if pr.Number == prNum {
+			return pr, nil
+		}
+	}
+	return vcs.PR{}, os.ErrNotExist
+}
+
+func day(d int) time.Time {
+	return time.Date(2020, 8, d, 0, 0, 0, 0, time.UTC)
+}
+
+func openTemp(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSyncBackfill(t *testing.T) {
+	s := openTemp(t)
+	client := &fakeClient{prs: []vcs.PR{
+		{Number: 1, Base: "master", MergedAt: day(2)},
+		{Number: 2, Base: "master", MergedAt: day(12)},
+	}}
+	ctx := context.Background()
+	bases := []string{"master"}
+
+	if prs, err := s.Sync(ctx, client, "github", "owner", "repo", bases, day(10)); err != nil || len(prs) != 1 {
+		t.Fatalf("first sync fetched %d PRs (%v), want 1", len(prs), err)
+	}
+	r, err := s.Load("github", "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := r.Synced["master"]
+	if !first.Start.Equal(day(10)) {
+		t.Errorf("synced from %s, want %s", first.Start, day(10))
+	}
+
+	client.windows = nil
+	if prs, err := s.Sync(ctx, client, "github", "owner", "repo", bases, day(1)); err != nil || len(prs) != 1 {
+		t.Fatalf("backfill fetched %d PRs (%v), want 1", len(prs), err)
+	}
+	if len(client.windows) != 2 || !client.windows[0].Start.Equal(day(1)) || !client.windows[0].End.Equal(day(10)) || !client.windows[1].Start.Equal(first.End) {
+		t.Errorf("listed %v, want the backfill from %s to %s then from %s", client.windows, day(1), day(10), first.End)
+	}
+	if r, err = s.Load("github", "owner", "repo"); err != nil {
+		t.Fatal(err)
+	}
+	if span := r.Synced["master"]; !span.Start.Equal(day(1)) || span.End.Before(first.End) {
+		t.Errorf("synced %s to %s, want %s to after %s", span.Start, span.End, day(1), first.End)
+	}
+
+	prs, err := NewClient(s, "github").GetMergedPRList(ctx, "owner", "repo", day(1), day(20), bases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 2 || prs[0].Number != 2 || prs[1].Number != 1 {
+		t.Errorf("reported %+v, want PRs 2 and 1", prs)
+	}
+}
+
+func TestSyncRefetchesWarnings(t *testing.T) {
+	s := openTemp(t)
+	client := &fakeClient{prs: []vcs.PR{
+		{Number: 1, Base: "master", MergedAt: day(2), Warnings: []string{"reviews: 502 Bad Gateway"}},
+		{Number: 2, Base: "master", MergedAt: day(3)},
+	}}
+	ctx := context.Background()
+	bases := []string{"master"}
+	if _, err := s.Sync(ctx, client, "github", "owner", "repo", bases, day(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	client.prs[0].Warnings = nil
+	prs, err := s.Sync(ctx, client, "github", "owner", "repo", bases, day(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 || prs[0].Number != 1 {
+		t.Errorf("fetched %+v, want PR 1 again", prs)
+	}
+	r, err := s.Load("github", "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := r.PRs[1].Warnings; len(w) != 0 {
+		t.Errorf("PR 1 still stored with warnings %v", w)
+	}
+}
+
+func TestSyncedSpanPerBase(t *testing.T) {
+	r := &Repo{Synced: map[string]Span{
+		basesKey([]string{"master", "release/*"}): {day(1), day(20)},
+		basesKey([]string{"develop"}):             {day(5), day(10)},
+	}}
+	tests := []struct {
+		bases   []string
+		want    Span
+		missing string
+	}{
+		{[]string{"release/*", "master"}, Span{day(1), day(20)}, ""},
+		{[]string{"release/1.0"}, Span{day(1), day(20)}, ""},
+		{[]string{"master", "develop"}, Span{day(5), day(10)}, ""},
+		{[]string{"master", "hotfix"}, Span{}, "hotfix"},
+	}
+	for _, tt := range tests {
+		span, missing, ok := r.syncedSpan(tt.bases)
+		if ok != (tt.missing == "") || missing != tt.missing || span != tt.want {
+			t.Errorf("syncedSpan(%v) = %v, %q, %t, want %v, %q", tt.bases, span, missing, ok, tt.want, tt.missing)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -32,6 +33,23 @@ func FullDurationFormater(avg, median time.Duration) string {
 	return fmt.Sprintf("AVG: %s\nMED: %s", aS, mS)
 }
 
+// SizeFormater formats the size of a PR in lines, when known.
+func SizeFormater(pr vcs.PR) string {
+	if pr.SizeUnknown {
+		return "--"
+	}
+	return strconv.Itoa(pr.ChangedLines)
+}
+
+// CommentsFormater formats a count of the listed comments of a PR, when
+// known.
+func CommentsFormater(pr vcs.PR, count int) string {
+	if pr.CommentsUnknown {
+		return "--"
+	}
+	return strconv.Itoa(count)
+}
+
 func DurationFormater(d time.Duration) string {
 
 	if d.Microseconds() == 0 {
@@ -45,10 +63,17 @@ func DurationFormater(d time.Duration) string {
 	return t
 }
 
-func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator bool) error {
-	rfb, err := getBranchReport(prs, from, to, includeCreator)
-	if err != nil {
-		return err
+func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator, partial bool, groupBy string, opts vcs.KPIOptions) error {
+	names, groups := groupPRs(prs, groupBy)
+	showBase := groupBy != "base" && severalBases(prs)
+	showLabels := anyLabels(prs)
+	reports := make([]string, len(names))
+	for i, name := range names {
+		var err error
+		reports[i], err = getBranchReport(groups[name], from, to, includeCreator, showBase, showLabels, opts)
+		if err != nil {
+			return err
+		}
 	}
 
 	myFigure := figure.NewColorFigure("Printing report...", "standard", "white", true)
@@ -57,38 +82,166 @@ func Render(prs []vcs.PR, owner, repo string, from, to time.Time, includeCreator
 	fmt.Println("\033[2J") //clean previous ouput
 	PrintPageHeader(owner, repo, from, to)
 	PrintReportHeader("Pull Request Report")
-	fmt.Println(rfb)
+	if partial {
+		fmt.Println(" PARTIAL REPORT: interrupted before all the PRs were fetched")
+		fmt.Println("")
+	}
+	for i, name := range names {
+		if groupBy != "" {
+			fmt.Printf(" %s: %s\n\n", strings.Title(groupBy), name)
+		}
+		fmt.Println(reports[i])
+	}
+	printReviewerLatencies(prs, opts)
+	printWarnings(prs)
 	return nil
 }
 
-func RenderSingle(pr vcs.PR) error {
+// noLabel is the group of the PRs without labels.
+const noLabel = "(no label)"
+
+// groupPRs splits the PRs by groupBy, keeping their order within each
+// group. Without groupBy, all the PRs are in a single group. When grouping
+// by label, a PR is in the group of each of its labels. Labels are compared
+// case-insensitively, as the label filters do, and a group is named after
+// the first spelling of its label.
+func groupPRs(prs []vcs.PR, groupBy string) (names []string, groups map[string][]vcs.PR) {
+	groups = map[string][]vcs.PR{}
+	if groupBy == "" {
+		return []string{""}, map[string][]vcs.PR{"": prs}
+	}
+	spelling := map[string]string{}
+	for _, pr := range prs {
+		keys := []string{pr.Base}
+		if groupBy == "label" {
+			keys = pr.Labels
+			if len(keys) == 0 {
+				keys = []string{noLabel}
+			}
+		}
+		grouped := map[string]bool{}
+		for _, key := range keys {
+			if groupBy == "label" {
+				folded := strings.ToLower(key)
+				if name, ok := spelling[folded]; ok {
+					key = name
+				} else {
+					spelling[folded] = key
+				}
+			}
+			if grouped[key] {
+				continue
+			}
+			grouped[key] = true
+			if _, ok := groups[key]; !ok {
+				names = append(names, key)
+			}
+			groups[key] = append(groups[key], pr)
+		}
+	}
+	sort.Strings(names)
+	return names, groups
+}
+
+func anyLabels(prs []vcs.PR) bool {
+	for _, pr := range prs {
+		if len(pr.Labels) > 0 || pr.Milestone != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func severalBases(prs []vcs.PR) bool {
+	for _, pr := range prs {
+		if pr.Base != prs[0].Base {
+			return true
+		}
+	}
+	return false
+}
+
+func RenderSingle(pr vcs.PR, opts vcs.KPIOptions) error {
 	fmt.Println("\033[2J") //clean previous ouput
 	PrintReportHeader(fmt.Sprintf("PR %d Report", pr.Number))
 
 	tableString := &strings.Builder{}
 	table := tablewriter.NewWriter(tableString)
-	header := []string{"Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Comments", "PR Lead Time", "Time To Merge"}
+	var header, row []string
+	if anyLabels([]vcs.PR{pr}) {
+		header = append(header, "Labels", "Milestone")
+		row = append(row, strings.Join(pr.Labels, ", "), pr.Milestone)
+	}
+	header = append(header, "Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Review Comments", "Conversation Comments", "Bot Comments", "PR Lead Time", "Time To Merge", "Time To First Approval", "Approval To Merge", "Approvals", "Changes Requested", "Review Request Latency", "Time In Draft")
 	table.SetHeader(header)
 
-	table.Append([]string{
+	row = append(row,
 		strconv.Itoa(pr.Commits),
-		strconv.Itoa(pr.ChangedLines),
-		DurationFormater(pr.TimeToFirstReview()),
+		SizeFormater(pr),
+		DurationFormater(pr.TimeToFirstReview(opts)),
 		DurationFormater(pr.TimeToReview()),
 		DurationFormater(pr.LastReviewToMerge()),
-		strconv.Itoa(pr.ReviewComments),
-		DurationFormater(pr.PRLeadTime()),
+		strconv.Itoa(pr.HumanReviewComments(opts)),
+		CommentsFormater(pr, pr.ConversationComments(opts)),
+		CommentsFormater(pr, pr.BotComments(opts)),
+		DurationFormater(pr.PRLeadTime(opts)),
 		DurationFormater(pr.TimeToMerge()),
-	})
+		DurationFormater(pr.TimeToFirstApproval(opts)),
+		DurationFormater(pr.ApprovalToMerge()),
+		strconv.Itoa(pr.ApprovalsCount()),
+		strconv.Itoa(pr.ChangesRequestedCount()),
+		DurationFormater(pr.ReviewRequestLatency()),
+		DurationFormater(pr.TimeInDraft()),
+	)
+	table.Append(row)
 
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetBorder(false)
 	table.Render() // Send output
 
 	fmt.Println(tableString.String())
+	printWarnings([]vcs.PR{pr})
 	return nil
 }
 
+// printReviewerLatencies shows how fast every reviewer answers the review
+// requests, slowest first.
+func printReviewerLatencies(prs []vcs.PR, opts vcs.KPIOptions) {
+	latencies := vcs.NewKPICalculator(prs, opts).ReviewerLatencies()
+	if len(latencies) == 0 {
+		return
+	}
+	tableString := &strings.Builder{}
+	table := tablewriter.NewWriter(tableString)
+	table.SetHeader([]string{"Reviewer", "Answered Requests", "Review Request Latency"})
+	for _, l := range latencies {
+		table.Append([]string{l.Reviewer, strconv.Itoa(l.Answered), FullDurationFormater(l.Avg, l.Median)})
+	}
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetBorder(false)
+	table.Render() // Send output
+
+	fmt.Println(" REVIEWERS")
+	fmt.Println(tableString.String())
+}
+
+// printWarnings lists the problems met while fetching the PRs, whose KPIs
+// may be incomplete.
+func printWarnings(prs []vcs.PR) {
+	var lines []string
+	for _, pr := range prs {
+		for _, w := range pr.Warnings {
+			lines = append(lines, fmt.Sprintf("  PR %d: %s", pr.Number, w))
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Println(" WARNINGS: the KPIs of these PRs may be incomplete")
+	fmt.Println(strings.Join(lines, "\n"))
+	fmt.Println("")
+}
+
 func PrintReportHeader(text string) {
 	figure.NewColorFigure(text, "small", "green", true).Print()
 	fmt.Println("")
@@ -101,40 +254,66 @@ func PrintPageHeader(owner, repo string, from time.Time, to time.Time) {
 	fmt.Println("")
 }
 
-func getBranchReport(prs []vcs.PR, from, to time.Time, includeCreator bool) (string, error) {
+func getBranchReport(prs []vcs.PR, from, to time.Time, includeCreator, showBase, showLabels bool, opts vcs.KPIOptions) (string, error) {
 	tableString := &strings.Builder{}
 	table := tablewriter.NewWriter(tableString)
 	header := []string{"PR"}
+	if showBase {
+		header = append(header, "Base")
+	}
 	if includeCreator {
 		header = append(header, "Creator")
 	}
-	header = append(header, "Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Comments", "PR Lead Time", "Time To Merge")
+	if showLabels {
+		header = append(header, "Labels", "Milestone")
+	}
+	header = append(header, "Commits", "Size", "Time To First Review", "Review time", "Last Review To Merge", "Review Comments", "Conversation Comments", "Bot Comments", "PR Lead Time", "Time To Merge", "Time To First Approval", "Approval To Merge", "Approvals", "Changes Requested", "Review Request Latency", "Time In Draft")
 	table.SetHeader(header)
 
 	for _, pr := range prs {
 		row := []string{strconv.Itoa(pr.Number)}
+		if showBase {
+			row = append(row, pr.Base)
+		}
 		if includeCreator {
 			row = append(row, pr.Creator)
 		}
+		if showLabels {
+			row = append(row, strings.Join(pr.Labels, ", "), pr.Milestone)
+		}
 		row = append(row,
 			strconv.Itoa(pr.Commits),
-			strconv.Itoa(pr.ChangedLines),
-			DurationFormater(pr.TimeToFirstReview()),
+			SizeFormater(pr),
+			DurationFormater(pr.TimeToFirstReview(opts)),
 			DurationFormater(pr.TimeToReview()),
 			DurationFormater(pr.LastReviewToMerge()),
-			strconv.Itoa(pr.ReviewComments),
-			DurationFormater(pr.PRLeadTime()),
+			strconv.Itoa(pr.HumanReviewComments(opts)),
+			CommentsFormater(pr, pr.ConversationComments(opts)),
+			CommentsFormater(pr, pr.BotComments(opts)),
+			DurationFormater(pr.PRLeadTime(opts)),
 			DurationFormater(pr.TimeToMerge()),
+			DurationFormater(pr.TimeToFirstApproval(opts)),
+			DurationFormater(pr.ApprovalToMerge()),
+			strconv.Itoa(pr.ApprovalsCount()),
+			strconv.Itoa(pr.ChangesRequestedCount()),
+			DurationFormater(pr.ReviewRequestLatency()),
+			DurationFormater(pr.TimeInDraft()),
 		)
 
 		table.Append(row)
 	}
 
-	kpi := vcs.NewKPICalculator(prs)
+	kpi := vcs.NewKPICalculator(prs, opts)
 	footer := []string{fmt.Sprintf("Count: %d", kpi.CountPR())}
+	if showBase {
+		footer = append(footer, "-")
+	}
 	if includeCreator {
 		footer = append(footer, "-")
 	}
+	if showLabels {
+		footer = append(footer, "-", "-")
+	}
 	footer = append(footer,
 		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgCommits(), kpi.MedianCommits()),
 		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgChangedLines(), kpi.MedianChangedLines()),
@@ -142,8 +321,16 @@ func getBranchReport(prs []vcs.PR, from, to time.Time, includeCreator bool) (str
 		FullDurationFormater(kpi.AvgTimeToReview(), kpi.MedianTimeToReview()),
 		FullDurationFormater(kpi.AvgLastReviewToMerge(), kpi.MedianLastReviewToMerge()),
 		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgReviews(), kpi.MedianReviews()),
+		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgConversationComments(), kpi.MedianConversationComments()),
+		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgBotComments(), kpi.MedianBotComments()),
 		FullDurationFormater(kpi.AvgPRLeadTime(), kpi.MedianPRLeadTime()),
 		FullDurationFormater(kpi.AvgTimeToMerge(), kpi.MedianTimeToMerge()),
+		FullDurationFormater(kpi.AvgTimeToFirstApproval(), kpi.MedianTimeToFirstApproval()),
+		FullDurationFormater(kpi.AvgApprovalToMerge(), kpi.MedianApprovalToMerge()),
+		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgApprovals(), kpi.MedianApprovals()),
+		fmt.Sprintf("AVG: %.2f\nMED: %.2f", kpi.AvgChangesRequested(), kpi.MedianChangesRequested()),
+		FullDurationFormater(kpi.AvgReviewRequestLatency(), kpi.MedianReviewRequestLatency()),
+		FullDurationFormater(kpi.AvgTimeInDraft(), kpi.MedianTimeInDraft()),
 	)
 
 	table.SetFooter(footer)
@@ -0,0 +1,27 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+func TestGroupPRsByLabelIgnoresCase(t *testing.T) {
+	prs := []vcs.PR{
+		{Number: 1, Labels: []string{"Bug"}},
+		{Number: 2, Labels: []string{"bug", "BUG"}},
+		{Number: 3},
+	}
+	names, groups := groupPRs(prs, "label")
+	if want := []string{"(no label)", "Bug"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("groups %v, want %v", names, want)
+	}
+	var bugs []int
+	for _, pr := range groups["Bug"] {
+		bugs = append(bugs, pr.Number)
+	}
+	if !reflect.DeepEqual(bugs, []int{1, 2}) {
+		t.Errorf("Bug group holds PRs %v, want [1 2]", bugs)
+	}
+}
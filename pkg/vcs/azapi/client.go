@@ -0,0 +1,315 @@
+package azapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+const (
+	apiVersion = "7.1"
+	pageSize   = 100
+	headsRef   = "refs/heads/"
+)
+
+// Client reads completed pull requests from Azure Repos. The owner of a
+// repository is its Azure DevOps project.
+type Client struct {
+	c             *http.Client
+	collectionURL string
+	token         string
+}
+
+type identity struct {
+	ID         string `json:"id"`
+	UniqueName string `json:"uniqueName"`
+}
+
+type pullRequest struct {
+	PullRequestID         int       `json:"pullRequestId"`
+	CreatedBy             identity  `json:"createdBy"`
+	CreationDate          time.Time `json:"creationDate"`
+	ClosedDate            time.Time `json:"closedDate"`
+	TargetRefName         string    `json:"targetRefName"`
+	LastMergeSourceCommit struct {
+		CommitID string `json:"commitId"`
+	} `json:"lastMergeSourceCommit"`
+	Labels []struct {
+		Name   string `json:"name"`
+		Active bool   `json:"active"`
+	} `json:"labels"`
+}
+
+type thread struct {
+	IsDeleted     bool             `json:"isDeleted"`
+	ThreadContext *json.RawMessage `json:"threadContext"`
+	Properties    struct {
+		ThreadType *struct {
+			Value string `json:"$value"`
+		} `json:"CodeReviewThreadType"`
+		VoteResult *struct {
+			Value string `json:"$value"`
+		} `json:"CodeReviewVoteResult"`
+	} `json:"properties"`
+	Comments []struct {
+		Author        identity  `json:"author"`
+		Content       string    `json:"content"`
+		CommentType   string    `json:"commentType"`
+		PublishedDate time.Time `json:"publishedDate"`
+		IsDeleted     bool      `json:"isDeleted"`
+	} `json:"comments"`
+}
+
+type iteration struct {
+	ID          int       `json:"id"`
+	CreatedDate time.Time `json:"createdDate"`
+}
+
+// NewClient returns a client for the Azure DevOps organization (or Azure
+// DevOps Server collection) at collectionURL, e.g. https://dev.azure.com/contoso,
+// authenticated with a personal access token.
+func NewClient(collectionURL, pat string) *Client {
+	return &Client{
+		c:             http.DefaultClient,
+		collectionURL: strings.TrimSuffix(collectionURL, "/"),
+		token:         pat,
+	}
+}
+
+func (cli *Client) repoURL(owner, repo string) string {
+	return fmt.Sprintf("%s/%s/_apis/git/repositories/%s", cli.collectionURL, url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func (cli *Client) get(ctx context.Context, u string, query url.Values, v interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", apiVersion)
+	u += "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", cli.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cli.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// an invalid PAT is answered with a redirect to the sign in page
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", u, err)
+	}
+	return nil
+}
+
+// getIterations returns the pushes to the PR source branch. Azure Repos has
+// no per PR commit listing, so every iteration counts as a commit.
+func (cli *Client) getIterations(ctx context.Context, owner, repo string, prNum int) ([]iteration, error) {
+	log.Printf("Getting iterations from %d", prNum)
+	var iterations struct {
+		Value []iteration `json:"value"`
+	}
+	if err := cli.get(ctx, fmt.Sprintf("%s/pullRequests/%d/iterations", cli.repoURL(owner, repo), prNum), nil, &iterations); err != nil {
+		return nil, err
+	}
+	sort.Slice(iterations.Value, func(i, j int) bool { return iterations.Value[i].CreatedDate.Before(iterations.Value[j].CreatedDate) })
+	return iterations.Value, nil
+}
+
+// getChangedFiles counts the files changed by the last iteration compared to
+// the target branch.
+func (cli *Client) getChangedFiles(ctx context.Context, owner, repo string, prNum, iterationID int) (int, error) {
+	files := 0
+	query := url.Values{"$compareTo": {"0"}, "$top": {"2000"}}
+	for {
+		var changes struct {
+			ChangeEntries []json.RawMessage `json:"changeEntries"`
+			NextSkip      int               `json:"nextSkip"`
+		}
+		if err := cli.get(ctx, fmt.Sprintf("%s/pullRequests/%d/iterations/%d/changes", cli.repoURL(owner, repo), prNum, iterationID), query, &changes); err != nil {
+			return 0, err
+		}
+		files += len(changes.ChangeEntries)
+		if changes.NextSkip == 0 {
+			return files, nil
+		}
+		query.Set("$skip", fmt.Sprint(changes.NextSkip))
+	}
+}
+
+// voteStates maps the reviewer votes onto the vcs review states. Waiting for
+// author (-5) and rejected (-10) both ask for changes.
+var voteStates = map[string]string{
+	"10":  vcs.ReviewApproved,
+	"5":   vcs.ReviewApproved,
+	"0":   vcs.ReviewDismissed,
+	"-5":  vcs.ReviewChangesRequested,
+	"-10": vcs.ReviewChangesRequested,
+}
+
+// getReviews collects the comments and votes of everyone but the PR
+// creator. Votes are recorded as system comments in VoteUpdate threads.
+func (cli *Client) getReviews(ctx context.Context, owner, repo string, pr pullRequest) (reviews []vcs.Review, fileComments int, err error) {
+	log.Printf("Getting reviews from %d", pr.PullRequestID)
+	var threads struct {
+		Value []thread `json:"value"`
+	}
+	if err = cli.get(ctx, fmt.Sprintf("%s/pullRequests/%d/threads", cli.repoURL(owner, repo), pr.PullRequestID), nil, &threads); err != nil {
+		return
+	}
+	for _, t := range threads.Value {
+		if t.IsDeleted {
+			continue
+		}
+		vote := t.Properties.ThreadType != nil && t.Properties.ThreadType.Value == "VoteUpdate"
+		for _, c := range t.Comments {
+			if c.IsDeleted || c.Author.ID == pr.CreatedBy.ID || (c.CommentType != "text" && !vote) {
+				continue
+			}
+			if c.CommentType == "text" && t.ThreadContext != nil {
+				fileComments++
+			}
+			r := vcs.Review{Author: c.Author.UniqueName, State: vcs.ReviewCommented, SubmittedAt: c.PublishedDate}
+			if c.CommentType == "text" {
+				r.BodyLength = len(c.Content)
+			} else if t.Properties.VoteResult != nil {
+				if state, ok := voteStates[t.Properties.VoteResult.Value]; ok {
+					r.State = state
+				}
+			}
+			reviews = append(reviews, r)
+		}
+	}
+	return
+}
+
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	query := url.Values{
+		"searchCriteria.status":             {"completed"},
+		"searchCriteria.queryTimeRangeType": {"closed"},
+		"searchCriteria.minTime":            {from.Format(time.RFC3339)},
+		"searchCriteria.maxTime":            {to.Format(time.RFC3339)},
+		"$top":                              {fmt.Sprint(pageSize)},
+	}
+	if base, single := vcs.SingleBase(bases); single {
+		var refs struct {
+			Value []struct {
+				Name string `json:"name"`
+			} `json:"value"`
+		}
+		if err := cli.get(ctx, cli.repoURL(owner, repo)+"/refs", url.Values{"filter": {"heads/" + base}}, &refs); err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		found := false
+		for _, r := range refs.Value {
+			if r.Name == headsRef+base {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("failed to get branch %q: not found", base)
+		}
+		query.Set("searchCriteria.targetRefName", headsRef+base)
+	}
+
+	var pRNums []int
+	log.Printf("Fetching Completed PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	for skip := 0; ; skip += pageSize {
+		query.Set("$skip", fmt.Sprint(skip))
+		var prs struct {
+			Value []pullRequest `json:"value"`
+		}
+		if err := cli.get(ctx, cli.repoURL(owner, repo)+"/pullrequests", query, &prs); err != nil {
+			return nil, err
+		}
+		for _, pr := range prs.Value {
+			if pr.ClosedDate.Before(from) || pr.ClosedDate.After(to) {
+				log.Printf("Discarded PR: %d out of the date range", pr.PullRequestID)
+				continue
+			}
+			if !vcs.MatchBase(bases, strings.TrimPrefix(pr.TargetRefName, headsRef)) {
+				continue
+			}
+			pRNums = append(pRNums, pr.PullRequestID)
+		}
+		if len(prs.Value) < pageSize {
+			break
+		}
+	}
+	return vcs.FetchPRs(ctx, pRNums, 1, func(ctx context.Context, prNum int) (vcs.PR, error) {
+		return cli.GetPRInfo(ctx, owner, repo, prNum)
+	})
+}
+
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for PR %d", prNum)
+	var pr pullRequest
+	if err := cli.get(ctx, fmt.Sprintf("%s/pullrequests/%d", cli.repoURL(owner, repo), prNum), nil, &pr); err != nil {
+		return vcs.PR{}, err
+	}
+
+	iterations, err := cli.getIterations(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	var fc, lc time.Time
+	changedFiles := 0
+	if len(iterations) > 0 {
+		fc = iterations[0].CreatedDate
+		lc = iterations[len(iterations)-1].CreatedDate
+		changedFiles, err = cli.getChangedFiles(ctx, owner, repo, prNum, iterations[len(iterations)-1].ID)
+		if err != nil {
+			return vcs.PR{}, err
+		}
+	}
+	reviews, reviewComments, err := cli.getReviews(ctx, owner, repo, pr)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].SubmittedAt.Before(reviews[j].SubmittedAt) })
+	fr, lr := vcs.ReviewSpan(reviews)
+
+	var labels []string
+	for _, l := range pr.Labels {
+		if l.Active {
+			labels = append(labels, l.Name)
+		}
+	}
+	return vcs.PR{
+		Number:          pr.PullRequestID,
+		Creator:         pr.CreatedBy.UniqueName,
+		CreatedAt:       pr.CreationDate,
+		MergedAt:        pr.ClosedDate,
+		ChangedFiles:    changedFiles,
+		ReviewComments:  reviewComments,
+		Base:            strings.TrimPrefix(pr.TargetRefName, headsRef),
+		Head:            pr.LastMergeSourceCommit.CommitID,
+		Commits:         len(iterations),
+		SizeUnknown:     true,
+		FirstCommitAt:   fc,
+		LastCommitAt:    lc,
+		FirstCommentAt:  fr,
+		LastCommentAt:   lr,
+		Reviews:         reviews,
+		CommentsUnknown: true,
+		Labels:          labels,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package azapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+func hour(h int) time.Time {
+	return time.Date(2020, 8, 1, h, 0, 0, 0, time.UTC)
+}
+
+// serveJSON answers every path of bodies with its JSON body, and fails the
+// test on any other request.
+func serveJSON(t *testing.T, bodies map[string]interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") != apiVersion {
+			t.Errorf("requested %s without api-version %s", r.URL.RequestURI(), apiVersion)
+		}
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL.RequestURI())
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+func TestGetMergedPRList(t *testing.T) {
+	creator := map[string]string{"id": "1", "uniqueName": "ann@contoso.com"}
+	bob := map[string]string{"id": "2", "uniqueName": "bob@contoso.com"}
+	eve := map[string]string{"id": "3", "uniqueName": "eve@contoso.com"}
+	pr := map[string]interface{}{
+		"pullRequestId":         7,
+		"createdBy":             creator,
+		"creationDate":          hour(0),
+		"closedDate":            hour(9),
+		"targetRefName":         "refs/heads/master",
+		"lastMergeSourceCommit": map[string]string{"commitId": "abc"},
+		"labels":                []interface{}{map[string]interface{}{"name": "bug", "active": true}, map[string]interface{}{"name": "old", "active": false}},
+	}
+	vote := func(at int, who map[string]string, result string) map[string]interface{} {
+		return map[string]interface{}{
+			"properties": map[string]interface{}{
+				"CodeReviewThreadType": map[string]string{"$value": "VoteUpdate"},
+				"CodeReviewVoteResult": map[string]string{"$value": result},
+			},
+			"comments": []interface{}{map[string]interface{}{"author": who, "commentType": "system", "publishedDate": hour(at)}},
+		}
+	}
+	comment := func(at int, who map[string]string, onFile bool) map[string]interface{} {
+		th := map[string]interface{}{
+			"comments": []interface{}{map[string]interface{}{"author": who, "content": "nit", "commentType": "text", "publishedDate": hour(at)}},
+		}
+		if onFile {
+			th["threadContext"] = map[string]string{"filePath": "/a.go"}
+		}
+		return th
+	}
+	repo := "/contoso/project/_apis/git/repositories/repo"
+	srv := httptest.NewServer(serveJSON(t, map[string]interface{}{
+		repo + "/pullrequests": map[string]interface{}{"value": []interface{}{
+			pr,
+			map[string]interface{}{"pullRequestId": 8, "closedDate": hour(30), "targetRefName": "refs/heads/master"},
+		}},
+		repo + "/pullrequests/7": pr,
+		repo + "/pullRequests/7/iterations": map[string]interface{}{"value": []interface{}{
+			map[string]interface{}{"id": 2, "createdDate": hour(3)},
+			map[string]interface{}{"id": 1, "createdDate": hour(1)},
+		}},
+		repo + "/pullRequests/7/iterations/2/changes": map[string]interface{}{"changeEntries": []interface{}{map[string]string{}, map[string]string{}}},
+		repo + "/pullRequests/7/threads": map[string]interface{}{"value": []interface{}{
+			comment(2, creator, true),
+			comment(4, bob, true),
+			comment(5, eve, false),
+			vote(6, eve, "-5"),
+			vote(7, bob, "5"),
+			vote(8, eve, "10"),
+		}},
+	}))
+	defer srv.Close()
+
+	cli := NewClient(srv.URL+"/contoso/", "pat")
+	prs, err := cli.GetMergedPRList(context.Background(), "project", "repo", hour(0), hour(20), []string{vcs.AllBases})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("listed %d PRs, want 1", len(prs))
+	}
+	got := prs[0]
+
+	// every iteration counts as a commit
+	if got.Commits != 2 || !got.FirstCommitAt.Equal(hour(1)) || !got.LastCommitAt.Equal(hour(3)) {
+		t.Errorf("commits = %d from %s to %s, want 2 from %s to %s", got.Commits, got.FirstCommitAt, got.LastCommitAt, hour(1), hour(3))
+	}
+	if got.ChangedFiles != 2 || !got.SizeUnknown {
+		t.Errorf("changed files = %d, size unknown = %t, want 2 and true", got.ChangedFiles, got.SizeUnknown)
+	}
+	wantReviews := []vcs.Review{
+		{Author: "bob@contoso.com", State: vcs.ReviewCommented, SubmittedAt: hour(4), BodyLength: 3},
+		{Author: "eve@contoso.com", State: vcs.ReviewCommented, SubmittedAt: hour(5), BodyLength: 3},
+		{Author: "eve@contoso.com", State: vcs.ReviewChangesRequested, SubmittedAt: hour(6)},
+		{Author: "bob@contoso.com", State: vcs.ReviewApproved, SubmittedAt: hour(7)},
+		{Author: "eve@contoso.com", State: vcs.ReviewApproved, SubmittedAt: hour(8)},
+	}
+	if !reflect.DeepEqual(got.Reviews, wantReviews) {
+		t.Errorf("Reviews = %+v, want %+v", got.Reviews, wantReviews)
+	}
+	if got.ReviewComments != 1 {
+		t.Errorf("ReviewComments = %d, want 1", got.ReviewComments)
+	}
+	if got.Creator != "ann@contoso.com" || got.Base != "master" || got.Head != "abc" || !got.MergedAt.Equal(hour(9)) {
+		t.Errorf("got %s merging %s into %s at %s", got.Creator, got.Head, got.Base, got.MergedAt)
+	}
+	if !reflect.DeepEqual(got.Labels, []string{"bug"}) {
+		t.Errorf("Labels = %v, want the active bug label", got.Labels)
+	}
+}
@@ -0,0 +1,49 @@
+package vcs
+
+import (
+	"path"
+	"strings"
+)
+
+// AllBases matches the PRs merged into any branch.
+const AllBases = "*"
+
+// ParseBases splits a comma separated list of base branches or patterns.
+func ParseBases(list string) []string {
+	return splitList(list)
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SingleBase returns the branch when bases is a single branch name rather
+// than a list or a pattern, so that providers can filter the PRs on the
+// API side and check that the branch exists.
+func SingleBase(bases []string) (string, bool) {
+	if len(bases) != 1 || strings.ContainsAny(bases[0], "*?[") {
+		return "", false
+	}
+	return bases[0], true
+}
+
+// MatchBase reports whether branch matches any of the bases. Bases are
+// branch names or path.Match patterns, such as release/*; AllBases matches
+// every branch, slashes included.
+func MatchBase(bases []string, branch string) bool {
+	for _, b := range bases {
+		if b == AllBases || b == branch {
+			return true
+		}
+		if ok, _ := path.Match(b, branch); ok {
+			return true
+		}
+	}
+	return false
+}
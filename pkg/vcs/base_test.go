@@ -0,0 +1,62 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBases(t *testing.T) {
+	if got, want := ParseBases(" master, release/*,,"), []string{"master", "release/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseBases = %q, want %q", got, want)
+	}
+}
+
+func TestSingleBase(t *testing.T) {
+	tests := []struct {
+		bases  []string
+		want   string
+		single bool
+	}{
+		{[]string{"master"}, "master", true},
+		{[]string{"release/1.0"}, "release/1.0", true},
+		{[]string{"*"}, "", false},
+		{[]string{"release/*"}, "", false},
+		{[]string{"release-?"}, "", false},
+		{[]string{"master", "develop"}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, single := SingleBase(tt.bases)
+		if got != tt.want || single != tt.single {
+			t.Errorf("SingleBase(%q) = %q, %t, want %q, %t", tt.bases, got, single, tt.want, tt.single)
+		}
+	}
+}
+
+func TestMatchBase(t *testing.T) {
+	tests := []struct {
+		bases  []string
+		branch string
+		want   bool
+	}{
+		{[]string{"*"}, "master", true},
+		{[]string{"*"}, "release/a/b", true},
+		{[]string{"master"}, "master", true},
+		{[]string{"master"}, "main", false},
+		{[]string{"release/*"}, "release/1.0", true},
+		{[]string{"release/*"}, "release/a/b", false},
+		{[]string{"release/*"}, "release", false},
+		{[]string{"release/*/*"}, "release/a/b", true},
+		{[]string{"main", "release/*"}, "release/1.0", true},
+		{[]string{"main", "release/*"}, "develop", false},
+		{[]string{"release/["}, "release/[", true}, // malformed patterns only match as names
+		{[]string{"release/["}, "release/1.0", false},
+		{[]string{}, "master", false},
+		{nil, "master", false},
+	}
+	for _, tt := range tests {
+		if got := MatchBase(tt.bases, tt.branch); got != tt.want {
+			t.Errorf("MatchBase(%q, %q) = %t, want %t", tt.bases, tt.branch, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package bbapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// httpClient holds what Bitbucket Cloud and Bitbucket Server have in common:
+// a base URL and either basic (username + app password) or bearer (access
+// token) authentication.
+type httpClient struct {
+	c        *http.Client
+	baseURL  string
+	username string
+	token    string
+}
+
+func newHTTPClient(baseURL, username, token string, c *http.Client) httpClient {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return httpClient{
+		c:        c,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		token:    token,
+	}
+}
+
+func (cli *httpClient) get(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if cli.username != "" {
+		req.SetBasicAuth(cli.username, cli.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+cli.token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cli.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", u, err)
+	}
+	return nil
+}
+
+// review is an approval or comment left on a pull request by someone other
+// than its author.
+type review struct {
+	vcs.Review
+	inline bool
+}
+
+// toReviews returns the reviews in submission order, along with the number
+// of inline comments among them.
+func toReviews(reviews []review) (list []vcs.Review, inline int) {
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].SubmittedAt.Before(reviews[j].SubmittedAt) })
+	for _, r := range reviews {
+		list = append(list, r.Review)
+		if r.inline {
+			inline++
+		}
+	}
+	return
+}
+
+func firstAndLast(times []time.Time) (first time.Time, last time.Time) {
+	for _, t := range times {
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return
+}
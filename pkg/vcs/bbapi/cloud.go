@@ -0,0 +1,268 @@
+package bbapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// CloudClient reads pull requests from Bitbucket Cloud (bitbucket.org).
+// The owner of a repository is its workspace.
+type CloudClient struct {
+	httpClient
+}
+
+type cloudUser struct {
+	Nickname string `json:"nickname"`
+	UUID     string `json:"uuid"`
+}
+
+type cloudPR struct {
+	ID          int        `json:"id"`
+	Author      cloudUser  `json:"author"`
+	CreatedOn   time.Time  `json:"created_on"`
+	UpdatedOn   time.Time  `json:"updated_on"`
+	ClosedOn    *time.Time `json:"closed_on"`
+	Destination struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"destination"`
+	Source struct {
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+}
+
+type cloudActivity struct {
+	Update *struct {
+		State string    `json:"state"`
+		Date  time.Time `json:"date"`
+	} `json:"update"`
+	Approval *struct {
+		Date time.Time `json:"date"`
+		User cloudUser `json:"user"`
+	} `json:"approval"`
+	ChangesRequested *struct {
+		Date time.Time `json:"date"`
+		User cloudUser `json:"user"`
+	} `json:"changes_requested"`
+	Comment *struct {
+		CreatedOn time.Time `json:"created_on"`
+		User      cloudUser `json:"user"`
+		Content   struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+		Inline *struct {
+			Path string `json:"path"`
+		} `json:"inline"`
+	} `json:"comment"`
+}
+
+type cloudCommit struct {
+	Date time.Time `json:"date"`
+}
+
+type cloudDiffStat struct {
+	LinesAdded   int `json:"lines_added"`
+	LinesRemoved int `json:"lines_removed"`
+}
+
+// NewCloudClient returns a client for the Bitbucket Cloud API at baseURL
+// (https://api.bitbucket.org). With a username the token is used as an app
+// password, otherwise as a bearer access token. Requests are sent with c, or
+// http.DefaultClient when nil.
+func NewCloudClient(baseURL, username, token string, c *http.Client) *CloudClient {
+	return &CloudClient{newHTTPClient(baseURL, username, token, c)}
+}
+
+func (cli *CloudClient) repoURL(owner, repo string) string {
+	return fmt.Sprintf("%s/2.0/repositories/%s/%s", cli.baseURL, url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func (cli *CloudClient) getActivity(ctx context.Context, owner, repo string, pr cloudPR) (mergedAt time.Time, reviews []review, err error) {
+	log.Printf("Getting activity from %d", pr.ID)
+	u := fmt.Sprintf("%s/pullrequests/%d/activity?pagelen=50", cli.repoURL(owner, repo), pr.ID)
+	for u != "" {
+		var page struct {
+			Values []cloudActivity `json:"values"`
+			Next   string          `json:"next"`
+		}
+		if err = cli.get(ctx, u, &page); err != nil {
+			return
+		}
+		for _, a := range page.Values {
+			switch {
+			case a.Update != nil:
+				if a.Update.State == "MERGED" {
+					mergedAt = a.Update.Date
+				}
+			case a.Approval != nil:
+				if a.Approval.User.UUID != pr.Author.UUID {
+					reviews = append(reviews, review{Review: vcs.Review{Author: a.Approval.User.Nickname, State: vcs.ReviewApproved, SubmittedAt: a.Approval.Date}})
+				}
+			case a.ChangesRequested != nil:
+				if a.ChangesRequested.User.UUID != pr.Author.UUID {
+					reviews = append(reviews, review{Review: vcs.Review{Author: a.ChangesRequested.User.Nickname, State: vcs.ReviewChangesRequested, SubmittedAt: a.ChangesRequested.Date}})
+				}
+			case a.Comment != nil:
+				if a.Comment.User.UUID != pr.Author.UUID {
+					reviews = append(reviews, review{
+						Review: vcs.Review{Author: a.Comment.User.Nickname, State: vcs.ReviewCommented, SubmittedAt: a.Comment.CreatedOn, BodyLength: len(a.Comment.Content.Raw)},
+						inline: a.Comment.Inline != nil,
+					})
+				}
+			}
+		}
+		u = page.Next
+	}
+	return
+}
+
+func (cli *CloudClient) getFirstAndLastCommitTime(ctx context.Context, owner, repo string, prNum int) (first time.Time, last time.Time, count int, err error) {
+	log.Printf("Getting first and last commit from %d", prNum)
+	var times []time.Time
+	u := fmt.Sprintf("%s/pullrequests/%d/commits?pagelen=50", cli.repoURL(owner, repo), prNum)
+	for u != "" {
+		var page struct {
+			Values []cloudCommit `json:"values"`
+			Next   string        `json:"next"`
+		}
+		if err = cli.get(ctx, u, &page); err != nil {
+			return
+		}
+		for _, c := range page.Values {
+			times = append(times, c.Date)
+		}
+		u = page.Next
+	}
+	first, last = firstAndLast(times)
+	return first, last, len(times), nil
+}
+
+func (cli *CloudClient) getChanges(ctx context.Context, owner, repo string, prNum int) (files int, lines int, err error) {
+	u := fmt.Sprintf("%s/pullrequests/%d/diffstat?pagelen=500", cli.repoURL(owner, repo), prNum)
+	for u != "" {
+		var page struct {
+			Values []cloudDiffStat `json:"values"`
+			Next   string          `json:"next"`
+		}
+		if err = cli.get(ctx, u, &page); err != nil {
+			return
+		}
+		for _, d := range page.Values {
+			lines += d.LinesAdded + d.LinesRemoved
+		}
+		files += len(page.Values)
+		u = page.Next
+	}
+	return
+}
+
+func (cli *CloudClient) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	// a PR merged in the window was created before its end and updated
+	// since its start
+	q := fmt.Sprintf("updated_on>=%s AND created_on<=%s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if base, single := vcs.SingleBase(bases); single {
+		if err := cli.get(ctx, fmt.Sprintf("%s/refs/branches/%s", cli.repoURL(owner, repo), url.PathEscape(base)), &struct{}{}); err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		q = fmt.Sprintf("destination.branch.name=%q AND %s", base, q)
+	}
+
+	var pRNums []int
+	query := url.Values{
+		"state":   {"MERGED"},
+		"q":       {q},
+		"sort":    {"-updated_on"},
+		"pagelen": {"50"},
+	}
+	log.Printf("Fetching Merged PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	u := cli.repoURL(owner, repo) + "/pullrequests?" + query.Encode()
+	for u != "" {
+		var page struct {
+			Values []cloudPR `json:"values"`
+			Next   string    `json:"next"`
+		}
+		if err := cli.get(ctx, u, &page); err != nil {
+			return nil, err
+		}
+		u = page.Next
+		for _, pr := range page.Values {
+			if pr.UpdatedOn.Before(from) {
+				// sorted by last update, so the rest were updated earlier
+				u = ""
+				break
+			}
+			if pr.ClosedOn != nil && (pr.ClosedOn.Before(from) || pr.ClosedOn.After(to)) {
+				log.Printf("Discarded PR: %d out of the date range", pr.ID)
+				continue
+			}
+			if vcs.MatchBase(bases, pr.Destination.Branch.Name) {
+				pRNums = append(pRNums, pr.ID)
+			}
+		}
+	}
+	// The list closes the PRs when they are merged, but the merge date is
+	// only known from their activity, so the candidates are checked again.
+	candidates, err := vcs.FetchPRs(ctx, pRNums, 1, func(ctx context.Context, prNum int) (vcs.PR, error) {
+		return cli.GetPRInfo(ctx, owner, repo, prNum)
+	})
+	var pRList []vcs.PR
+	for _, pr := range candidates {
+		if pr.MergedAt.Before(from) || pr.MergedAt.After(to) {
+			log.Printf("Discarded PR: %d out of the date range", pr.Number)
+			continue
+		}
+		pRList = append(pRList, pr)
+	}
+	return pRList, err
+}
+
+func (cli *CloudClient) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for PR %d", prNum)
+	var pr cloudPR
+	if err := cli.get(ctx, fmt.Sprintf("%s/pullrequests/%d", cli.repoURL(owner, repo), prNum), &pr); err != nil {
+		return vcs.PR{}, err
+	}
+
+	fc, lc, commits, err := cli.getFirstAndLastCommitTime(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	files, lines, err := cli.getChanges(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	mergedAt, reviews, err := cli.getActivity(ctx, owner, repo, pr)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	reviewList, reviewComments := toReviews(reviews)
+	fr, lr := vcs.ReviewSpan(reviewList)
+
+	return vcs.PR{
+		Number:          pr.ID,
+		Creator:         pr.Author.Nickname,
+		CreatedAt:       pr.CreatedOn,
+		MergedAt:        mergedAt,
+		ChangedFiles:    files,
+		ChangedLines:    lines,
+		ReviewComments:  reviewComments,
+		Base:            pr.Destination.Branch.Name,
+		Head:            pr.Source.Commit.Hash,
+		Commits:         commits,
+		FirstCommitAt:   fc,
+		LastCommitAt:    lc,
+		FirstCommentAt:  fr,
+		LastCommentAt:   lr,
+		Reviews:         reviewList,
+		CommentsUnknown: true,
+	}, nil
+}
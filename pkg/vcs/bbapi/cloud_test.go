@@ -0,0 +1,165 @@
+package bbapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// serveJSON returns a handler answering every path of pages with its JSON
+// body, and failing the test on any other request.
+func serveJSON(t *testing.T, pages map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.RequestURI()]
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL.RequestURI())
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func hour(h int) time.Time {
+	return time.Date(2020, 8, 1, h, 0, 0, 0, time.UTC)
+}
+
+func TestCloudGetPRInfo(t *testing.T) {
+	at := func(h int) string { return hour(h).Format(time.RFC3339) }
+	author := map[string]string{"nickname": "ann", "uuid": "{ann}"}
+	bob := map[string]string{"nickname": "bob", "uuid": "{bob}"}
+	eve := map[string]string{"nickname": "eve", "uuid": "{eve}"}
+
+	pages := map[string]string{}
+	srv := httptest.NewServer(serveJSON(t, pages))
+	defer srv.Close()
+	pr := "/2.0/repositories/ws/repo/pullrequests/7"
+	pages[pr] = mustJSON(t, map[string]interface{}{
+		"id":          7,
+		"author":      author,
+		"created_on":  at(0),
+		"destination": map[string]interface{}{"branch": map[string]string{"name": "master"}},
+		"source":      map[string]interface{}{"commit": map[string]string{"hash": "abc"}},
+	})
+	pages[pr+"/commits?pagelen=50"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{map[string]string{"date": at(2)}},
+		"next":   srv.URL + pr + "/commits?pagelen=50&page=2",
+	})
+	pages[pr+"/commits?pagelen=50&page=2"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{map[string]string{"date": at(1)}},
+	})
+	pages[pr+"/diffstat?pagelen=500"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{map[string]int{"lines_added": 3, "lines_removed": 1}},
+	})
+	pages[pr+"/activity?pagelen=50"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{
+			map[string]interface{}{"comment": map[string]interface{}{"created_on": at(3), "user": author, "content": map[string]string{"raw": "own"}}},
+			map[string]interface{}{"comment": map[string]interface{}{"created_on": at(4), "user": bob, "content": map[string]string{"raw": "nit"}, "inline": map[string]string{"path": "a.go"}}},
+			map[string]interface{}{"changes_requested": map[string]interface{}{"date": at(5), "user": eve}},
+		},
+		"next": srv.URL + pr + "/activity?pagelen=50&page=2",
+	})
+	pages[pr+"/activity?pagelen=50&page=2"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{
+			map[string]interface{}{"comment": map[string]interface{}{"created_on": at(6), "user": eve, "content": map[string]string{"raw": "why?"}}},
+			map[string]interface{}{"approval": map[string]interface{}{"date": at(7), "user": bob}},
+			map[string]interface{}{"update": map[string]interface{}{"state": "MERGED", "date": at(8)}},
+		},
+	})
+
+	cli := NewCloudClient(srv.URL, "", "token", srv.Client())
+	got, err := cli.GetPRInfo(context.Background(), "ws", "repo", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantReviews := []vcs.Review{
+		{Author: "bob", State: vcs.ReviewCommented, SubmittedAt: hour(4), BodyLength: 3},
+		{Author: "eve", State: vcs.ReviewChangesRequested, SubmittedAt: hour(5)},
+		{Author: "eve", State: vcs.ReviewCommented, SubmittedAt: hour(6), BodyLength: 4},
+		{Author: "bob", State: vcs.ReviewApproved, SubmittedAt: hour(7)},
+	}
+	if !reflect.DeepEqual(got.Reviews, wantReviews) {
+		t.Errorf("Reviews = %+v, want %+v", got.Reviews, wantReviews)
+	}
+	if got.ReviewComments != 1 {
+		t.Errorf("ReviewComments = %d, want 1", got.ReviewComments)
+	}
+	if got.Commits != 2 || !got.FirstCommitAt.Equal(hour(1)) || !got.LastCommitAt.Equal(hour(2)) {
+		t.Errorf("commits = %d from %s to %s, want 2 from %s to %s", got.Commits, got.FirstCommitAt, got.LastCommitAt, hour(1), hour(2))
+	}
+	if !got.MergedAt.Equal(hour(8)) {
+		t.Errorf("MergedAt = %s, want %s", got.MergedAt, hour(8))
+	}
+	if got.ChangedLines != 4 || got.ChangedFiles != 1 {
+		t.Errorf("changes = %d lines in %d files, want 4 lines in 1 file", got.ChangedLines, got.ChangedFiles)
+	}
+	if !got.FirstCommentAt.Equal(hour(4)) || !got.LastCommentAt.Equal(hour(7)) {
+		t.Errorf("review span = %s to %s, want %s to %s", got.FirstCommentAt, got.LastCommentAt, hour(4), hour(7))
+	}
+}
+
+// TestCloudGetMergedPRList checks that only the PRs closed in the window are
+// fetched, and that the paging stops at the first PR updated before it.
+func TestCloudGetMergedPRList(t *testing.T) {
+	from, to := hour(10), hour(20)
+	listed := func(id int, created, updated int, closed int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":          id,
+			"created_on":  hour(created).Format(time.RFC3339),
+			"updated_on":  hour(updated).Format(time.RFC3339),
+			"closed_on":   hour(closed).Format(time.RFC3339),
+			"destination": map[string]interface{}{"branch": map[string]string{"name": "master"}},
+		}
+	}
+
+	pages := map[string]string{}
+	srv := httptest.NewServer(serveJSON(t, pages))
+	defer srv.Close()
+	list := "/2.0/repositories/ws/repo/pullrequests?" + url.Values{
+		"state":   {"MERGED"},
+		"q":       {"updated_on>=" + from.Format(time.RFC3339) + " AND created_on<=" + to.Format(time.RFC3339)},
+		"sort":    {"-updated_on"},
+		"pagelen": {"50"},
+	}.Encode()
+	pages[list] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{
+			listed(3, 15, 23, 22), // merged after the window
+			listed(2, 11, 19, 18),
+			listed(1, 1, 9, 5), // updated before the window, ends the list
+		},
+		"next": srv.URL + list + "&page=2",
+	})
+	pr := "/2.0/repositories/ws/repo/pullrequests/2"
+	pages[pr] = mustJSON(t, listed(2, 11, 19, 18))
+	pages[pr+"/commits?pagelen=50"] = `{"values":[]}`
+	pages[pr+"/diffstat?pagelen=500"] = `{"values":[]}`
+	pages[pr+"/activity?pagelen=50"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{map[string]interface{}{"update": map[string]interface{}{"state": "MERGED", "date": hour(18).Format(time.RFC3339)}}},
+	})
+
+	cli := NewCloudClient(srv.URL, "", "token", srv.Client())
+	prs, err := cli.GetMergedPRList(context.Background(), "ws", "repo", from, to, []string{vcs.AllBases})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 1 || prs[0].Number != 2 {
+		t.Errorf("listed %+v, want PR 2", prs)
+	}
+}
@@ -0,0 +1,268 @@
+package bbapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// ServerClient reads pull requests from Bitbucket Server and Data Center.
+// The owner of a repository is its project key.
+type ServerClient struct {
+	httpClient
+}
+
+type serverUser struct {
+	Slug string `json:"slug"`
+}
+
+type serverPR struct {
+	ID          int   `json:"id"`
+	CreatedDate int64 `json:"createdDate"`
+	UpdatedDate int64 `json:"updatedDate"`
+	ClosedDate  int64 `json:"closedDate"`
+	Author      struct {
+		User serverUser `json:"user"`
+	} `json:"author"`
+	FromRef struct {
+		LatestCommit string `json:"latestCommit"`
+	} `json:"fromRef"`
+	ToRef struct {
+		DisplayID string `json:"displayId"`
+	} `json:"toRef"`
+}
+
+type serverActivity struct {
+	Action        string     `json:"action"`
+	CreatedDate   int64      `json:"createdDate"`
+	User          serverUser `json:"user"`
+	CommentAnchor *struct{}  `json:"commentAnchor"`
+	Comment       *struct {
+		Text string `json:"text"`
+	} `json:"comment"`
+}
+
+type serverCommit struct {
+	CommitterTimestamp int64 `json:"committerTimestamp"`
+}
+
+type serverDiff struct {
+	Diffs []struct {
+		Hunks []struct {
+			Segments []struct {
+				Type  string            `json:"type"`
+				Lines []json.RawMessage `json:"lines"`
+			} `json:"segments"`
+		} `json:"hunks"`
+	} `json:"diffs"`
+}
+
+// page is the envelope of every paged Bitbucket Server collection.
+type page struct {
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+// NewServerClient returns a client for the Bitbucket Server instance at
+// baseURL. With a username the token is used as a password, otherwise as a
+// bearer HTTP access token. Requests are sent with c, or http.DefaultClient
+// when nil.
+func NewServerClient(baseURL, username, token string, c *http.Client) *ServerClient {
+	return &ServerClient{newHTTPClient(baseURL, username, token, c)}
+}
+
+func millis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func (cli *ServerClient) repoURL(owner, repo string) string {
+	return fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s", cli.baseURL, url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func (cli *ServerClient) getActivities(ctx context.Context, owner, repo string, pr serverPR) (reviews []review, err error) {
+	log.Printf("Getting activities from %d", pr.ID)
+	for start := 0; ; {
+		var p struct {
+			page
+			Values []serverActivity `json:"values"`
+		}
+		if err = cli.get(ctx, fmt.Sprintf("%s/pull-requests/%d/activities?limit=100&start=%d", cli.repoURL(owner, repo), pr.ID, start), &p); err != nil {
+			return nil, err
+		}
+		for _, a := range p.Values {
+			if a.User.Slug == pr.Author.User.Slug {
+				continue
+			}
+			r := review{Review: vcs.Review{Author: a.User.Slug, SubmittedAt: millis(a.CreatedDate)}}
+			switch a.Action {
+			case "APPROVED":
+				r.State = vcs.ReviewApproved
+			case "REVIEWED": // marked as needing work
+				r.State = vcs.ReviewChangesRequested
+			case "COMMENTED":
+				r.State = vcs.ReviewCommented
+				r.inline = a.CommentAnchor != nil
+				if a.Comment != nil {
+					r.BodyLength = len(a.Comment.Text)
+				}
+			default:
+				continue
+			}
+			reviews = append(reviews, r)
+		}
+		if p.IsLastPage {
+			return reviews, nil
+		}
+		start = p.NextPageStart
+	}
+}
+
+func (cli *ServerClient) getFirstAndLastCommitTime(ctx context.Context, owner, repo string, prNum int) (first time.Time, last time.Time, count int, err error) {
+	log.Printf("Getting first and last commit from %d", prNum)
+	var times []time.Time
+	for start := 0; ; {
+		var p struct {
+			page
+			Values []serverCommit `json:"values"`
+		}
+		if err = cli.get(ctx, fmt.Sprintf("%s/pull-requests/%d/commits?limit=100&start=%d", cli.repoURL(owner, repo), prNum, start), &p); err != nil {
+			return
+		}
+		for _, c := range p.Values {
+			times = append(times, millis(c.CommitterTimestamp))
+		}
+		if p.IsLastPage {
+			break
+		}
+		start = p.NextPageStart
+	}
+	first, last = firstAndLast(times)
+	return first, last, len(times), nil
+}
+
+func (cli *ServerClient) getChanges(ctx context.Context, owner, repo string, prNum int) (files int, lines int, err error) {
+	var d serverDiff
+	if err = cli.get(ctx, fmt.Sprintf("%s/pull-requests/%d/diff?contextLines=0&whitespace=show", cli.repoURL(owner, repo), prNum), &d); err != nil {
+		return
+	}
+	for _, f := range d.Diffs {
+		for _, h := range f.Hunks {
+			for _, s := range h.Segments {
+				if s.Type == "ADDED" || s.Type == "REMOVED" {
+					lines += len(s.Lines)
+				}
+			}
+		}
+	}
+	return len(d.Diffs), lines, nil
+}
+
+func (cli *ServerClient) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	at := ""
+	if base, single := vcs.SingleBase(bases); single {
+		ref := "refs/heads/" + base
+		var branches struct {
+			Values []struct {
+				ID string `json:"id"`
+			} `json:"values"`
+		}
+		if err := cli.get(ctx, fmt.Sprintf("%s/branches?filterText=%s", cli.repoURL(owner, repo), url.QueryEscape(base)), &branches); err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		found := false
+		for _, b := range branches.Values {
+			found = found || b.ID == ref
+		}
+		if !found {
+			return nil, fmt.Errorf("failed to get branch %q: not found", base)
+		}
+		at = "&at=" + url.QueryEscape(ref)
+	}
+
+	var pRNums []int
+	log.Printf("Fetching Merged PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+pagination:
+	for start := 0; ; {
+		var p struct {
+			page
+			Values []serverPR `json:"values"`
+		}
+		u := fmt.Sprintf("%s/pull-requests?state=MERGED&order=NEWEST&limit=100&start=%d%s", cli.repoURL(owner, repo), start, at)
+		if err := cli.get(ctx, u, &p); err != nil {
+			return nil, err
+		}
+		for _, pr := range p.Values {
+			if millis(pr.UpdatedDate).Before(from) {
+				// NEWEST sorts by update date and merging updates a PR, so
+				// nothing older can have been merged in the window
+				break pagination
+			}
+			if closed := millis(pr.ClosedDate); closed.Before(from) || closed.After(to) {
+				log.Printf("Discarded PR: %d out of the date range", pr.ID)
+				continue
+			}
+			if !vcs.MatchBase(bases, pr.ToRef.DisplayID) {
+				continue
+			}
+			pRNums = append(pRNums, pr.ID)
+		}
+		if p.IsLastPage {
+			break
+		}
+		start = p.NextPageStart
+	}
+	return vcs.FetchPRs(ctx, pRNums, 1, func(ctx context.Context, prNum int) (vcs.PR, error) {
+		return cli.GetPRInfo(ctx, owner, repo, prNum)
+	})
+}
+
+func (cli *ServerClient) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for PR %d", prNum)
+	var pr serverPR
+	if err := cli.get(ctx, fmt.Sprintf("%s/pull-requests/%d", cli.repoURL(owner, repo), prNum), &pr); err != nil {
+		return vcs.PR{}, err
+	}
+
+	fc, lc, commits, err := cli.getFirstAndLastCommitTime(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	files, lines, err := cli.getChanges(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	reviews, err := cli.getActivities(ctx, owner, repo, pr)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	reviewList, reviewComments := toReviews(reviews)
+	fr, lr := vcs.ReviewSpan(reviewList)
+
+	return vcs.PR{
+		Number:          pr.ID,
+		Creator:         pr.Author.User.Slug,
+		CreatedAt:       millis(pr.CreatedDate),
+		MergedAt:        millis(pr.ClosedDate),
+		ChangedFiles:    files,
+		ChangedLines:    lines,
+		ReviewComments:  reviewComments,
+		Base:            pr.ToRef.DisplayID,
+		Head:            pr.FromRef.LatestCommit,
+		Commits:         commits,
+		FirstCommitAt:   fc,
+		LastCommitAt:    lc,
+		FirstCommentAt:  fr,
+		LastCommentAt:   lr,
+		Reviews:         reviewList,
+		CommentsUnknown: true,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package bbapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+func TestServerGetPRInfo(t *testing.T) {
+	ms := func(h int) int64 { return hour(h).UnixNano() / 1e6 }
+	author := map[string]string{"slug": "ann"}
+	bob := map[string]string{"slug": "bob"}
+	eve := map[string]string{"slug": "eve"}
+
+	pages := map[string]string{}
+	srv := httptest.NewServer(serveJSON(t, pages))
+	defer srv.Close()
+	pr := "/rest/api/1.0/projects/PRJ/repos/repo/pull-requests/7"
+	pages[pr] = mustJSON(t, map[string]interface{}{
+		"id":          7,
+		"createdDate": ms(0),
+		"closedDate":  ms(8),
+		"author":      map[string]interface{}{"user": author},
+		"fromRef":     map[string]string{"latestCommit": "abc"},
+		"toRef":       map[string]string{"displayId": "master"},
+	})
+	pages[pr+"/commits?limit=100&start=0"] = mustJSON(t, map[string]interface{}{
+		"values":        []interface{}{map[string]int64{"committerTimestamp": ms(2)}},
+		"isLastPage":    false,
+		"nextPageStart": 1,
+	})
+	pages[pr+"/commits?limit=100&start=1"] = mustJSON(t, map[string]interface{}{
+		"values":     []interface{}{map[string]int64{"committerTimestamp": ms(1)}},
+		"isLastPage": true,
+	})
+	pages[pr+"/diff?contextLines=0&whitespace=show"] = mustJSON(t, map[string]interface{}{
+		"diffs": []interface{}{map[string]interface{}{"hunks": []interface{}{map[string]interface{}{"segments": []interface{}{
+			map[string]interface{}{"type": "ADDED", "lines": []interface{}{map[string]int{"line": 1}, map[string]int{"line": 2}}},
+			map[string]interface{}{"type": "CONTEXT", "lines": []interface{}{map[string]int{"line": 3}}},
+			map[string]interface{}{"type": "REMOVED", "lines": []interface{}{map[string]int{"line": 4}}},
+		}}}}},
+	})
+	pages[pr+"/activities?limit=100&start=0"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{
+			map[string]interface{}{"action": "APPROVED", "createdDate": ms(7), "user": bob},
+			map[string]interface{}{"action": "COMMENTED", "createdDate": ms(6), "user": eve, "comment": map[string]string{"text": "why?"}},
+			map[string]interface{}{"action": "COMMENTED", "createdDate": ms(5), "user": author, "comment": map[string]string{"text": "own"}},
+		},
+		"isLastPage":    false,
+		"nextPageStart": 3,
+	})
+	pages[pr+"/activities?limit=100&start=3"] = mustJSON(t, map[string]interface{}{
+		"values": []interface{}{
+			map[string]interface{}{"action": "REVIEWED", "createdDate": ms(4), "user": eve},
+			map[string]interface{}{"action": "COMMENTED", "createdDate": ms(3), "user": bob, "comment": map[string]string{"text": "nit"}, "commentAnchor": map[string]string{"path": "a.go"}},
+			map[string]interface{}{"action": "OPENED", "createdDate": ms(0), "user": author},
+		},
+		"isLastPage": true,
+	})
+
+	cli := NewServerClient(srv.URL, "", "token", srv.Client())
+	got, err := cli.GetPRInfo(context.Background(), "PRJ", "repo", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantReviews := []vcs.Review{
+		{Author: "bob", State: vcs.ReviewCommented, SubmittedAt: millis(ms(3)), BodyLength: 3},
+		{Author: "eve", State: vcs.ReviewChangesRequested, SubmittedAt: millis(ms(4))},
+		{Author: "eve", State: vcs.ReviewCommented, SubmittedAt: millis(ms(6)), BodyLength: 4},
+		{Author: "bob", State: vcs.ReviewApproved, SubmittedAt: millis(ms(7))},
+	}
+	if !reflect.DeepEqual(got.Reviews, wantReviews) {
+		t.Errorf("Reviews = %+v, want %+v", got.Reviews, wantReviews)
+	}
+	if got.ReviewComments != 1 {
+		t.Errorf("ReviewComments = %d, want 1", got.ReviewComments)
+	}
+	if got.Commits != 2 || !got.FirstCommitAt.Equal(hour(1)) || !got.LastCommitAt.Equal(hour(2)) {
+		t.Errorf("commits = %d from %s to %s, want 2 from %s to %s", got.Commits, got.FirstCommitAt, got.LastCommitAt, hour(1), hour(2))
+	}
+	if !got.MergedAt.Equal(hour(8)) {
+		t.Errorf("MergedAt = %s, want %s", got.MergedAt, hour(8))
+	}
+	if got.ChangedLines != 3 || got.ChangedFiles != 1 {
+		t.Errorf("changes = %d lines in %d files, want 3 lines in 1 file", got.ChangedLines, got.ChangedFiles)
+	}
+}
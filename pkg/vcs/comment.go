@@ -0,0 +1,88 @@
+package vcs
+
+import (
+	"strings"
+	"time"
+)
+
+// Comment kinds.
+const (
+	// CommentReview is an inline comment on the diff.
+	CommentReview = "review"
+	// CommentConversation is a comment on the conversation of the PR.
+	CommentConversation = "conversation"
+)
+
+// Comment is a comment left on a PR.
+type Comment struct {
+	Author    string
+	Kind      string
+	CreatedAt time.Time
+}
+
+// DefaultBots matches the accounts of the GitHub Apps, such as
+// dependabot[bot].
+var DefaultBots = []string{"*[bot]"}
+
+// ParseBots splits a comma separated list of bot accounts.
+func ParseBots(list string) []string {
+	return splitList(list)
+}
+
+// IsBot reports whether login is one of bots. A bot starting with * matches
+// the logins ending with the rest of it. Logins are compared
+// case-insensitively.
+func IsBot(bots []string, login string) bool {
+	login = strings.ToLower(login)
+	for _, b := range bots {
+		b = strings.ToLower(b)
+		if b == login || (strings.HasPrefix(b, "*") && strings.HasSuffix(login, b[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts KPIOptions) isBot(login string) bool {
+	if opts.Bots == nil {
+		return IsBot(DefaultBots, login)
+	}
+	return IsBot(opts.Bots, login)
+}
+
+// HumanReviewComments counts the inline comments left by humans. It is
+// ReviewComments for the providers that don't list the comments.
+func (pr *PR) HumanReviewComments(opts KPIOptions) int {
+	if pr.CommentsUnknown {
+		return pr.ReviewComments
+	}
+	return pr.countComments(CommentReview, opts)
+}
+
+// ConversationComments counts the comments left by humans on the
+// conversation of the PR. It is 0 when CommentsUnknown is set.
+func (pr *PR) ConversationComments(opts KPIOptions) int {
+	return pr.countComments(CommentConversation, opts)
+}
+
+// BotComments counts the comments of any kind left by bots. It is 0 when
+// CommentsUnknown is set.
+func (pr *PR) BotComments(opts KPIOptions) int {
+	n := 0
+	for _, c := range pr.Comments {
+		if opts.isBot(c.Author) {
+			n++
+		}
+	}
+	return n
+}
+
+func (pr *PR) countComments(kind string, opts KPIOptions) int {
+	n := 0
+	for _, c := range pr.Comments {
+		if c.Kind == kind && !opts.isBot(c.Author) {
+			n++
+		}
+	}
+	return n
+}
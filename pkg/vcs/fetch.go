@@ -0,0 +1,103 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PRError is the failure to fetch a single PR.
+type PRError struct {
+	Number int
+	Err    error
+}
+
+// FetchError collects the PRs that could not be fetched by FetchPRs.
+type FetchError []PRError
+
+func (e FetchError) Error() string {
+	msgs := make([]string, len(e))
+	for i, pe := range e {
+		msgs[i] = fmt.Sprintf("PR %d: %s", pe.Number, pe.Err)
+	}
+	return "failed to fetch " + strings.Join(msgs, "; ")
+}
+
+// FetchPRs calls fetch for every PR number with at most concurrency calls in
+// flight, and returns the PRs in the order of nums.
+//
+// After the first failure no more PRs are started; the ones already in
+// flight are awaited and their failures are also part of the returned
+// FetchError. When ctx is cancelled, the PRs fetched so far are returned
+// along with the context error.
+func FetchPRs(ctx context.Context, nums []int, concurrency int, fetch func(ctx context.Context, prNum int) (PR, error)) ([]PR, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	prs := make([]PR, len(nums))
+	fetched := make([]bool, len(nums))
+	errs := make([]error, len(nums))
+	jobs := make(chan int)
+	failed := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				pr, err := fetch(ctx, nums[i])
+				if err != nil {
+					errs[i] = err
+					once.Do(func() { close(failed) })
+					continue
+				}
+				prs[i] = pr
+				fetched[i] = true
+			}
+		}()
+	}
+
+dispatch:
+	for i := range nums {
+		select {
+		case <-failed:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		default:
+		}
+		select {
+		case jobs <- i:
+		case <-failed:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		var partial []PR
+		for i, pr := range prs {
+			if fetched[i] {
+				partial = append(partial, pr)
+			}
+		}
+		return partial, err
+	}
+
+	var fetchErr FetchError
+	for i, err := range errs {
+		if err != nil {
+			fetchErr = append(fetchErr, PRError{nums[i], err})
+		}
+	}
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return prs, nil
+}
@@ -0,0 +1,103 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func numbers(prs []PR) []int {
+	var nums []int
+	for _, pr := range prs {
+		nums = append(nums, pr.Number)
+	}
+	return nums
+}
+
+func TestFetchPRsKeepsOrder(t *testing.T) {
+	nums := []int{5, 3, 9, 1, 7}
+	prs, err := FetchPRs(context.Background(), nums, 3, func(ctx context.Context, prNum int) (PR, error) {
+		// the first PRs finish last
+		time.Sleep(time.Duration(10-prNum) * time.Millisecond)
+		return PR{Number: prNum}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := numbers(prs); !reflect.DeepEqual(got, nums) {
+		t.Errorf("fetched %v, want %v", got, nums)
+	}
+}
+
+func TestFetchPRsBoundsConcurrency(t *testing.T) {
+	const concurrency = 3
+	var inFlight, max int32
+	nums := make([]int, 20)
+	for i := range nums {
+		nums[i] = i + 1
+	}
+	_, err := FetchPRs(context.Background(), nums, concurrency, func(ctx context.Context, prNum int) (PR, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return PR{Number: prNum}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max > concurrency {
+		t.Errorf("%d fetches in flight, want at most %d", max, concurrency)
+	}
+}
+
+func TestFetchPRsStopsAfterFailure(t *testing.T) {
+	fail := errors.New("502 Bad Gateway")
+	var calls int32
+	prs, err := FetchPRs(context.Background(), []int{1, 2, 3, 4, 5, 6, 7, 8}, 1, func(ctx context.Context, prNum int) (PR, error) {
+		atomic.AddInt32(&calls, 1)
+		if prNum == 3 {
+			return PR{}, fail
+		}
+		return PR{Number: prNum}, nil
+	})
+	if prs != nil {
+		t.Errorf("fetched %v along with the failure", numbers(prs))
+	}
+	var fetchErr FetchError
+	if !errors.As(err, &fetchErr) || len(fetchErr) != 1 || fetchErr[0].Number != 3 || fetchErr[0].Err != fail {
+		t.Fatalf("err = %v, want the failure of PR 3", err)
+	}
+	// the PR being handed over when the failure happened may still start
+	if calls > 4 {
+		t.Errorf("fetched %d PRs, want no more started after the failure", calls)
+	}
+}
+
+func TestFetchPRsReturnsPartialOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prs, err := FetchPRs(ctx, []int{1, 2, 3, 4, 5}, 1, func(ctx context.Context, prNum int) (PR, error) {
+		if err := ctx.Err(); err != nil {
+			return PR{}, err
+		}
+		if prNum == 2 {
+			cancel()
+		}
+		return PR{Number: prNum}, nil
+	})
+	if err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if got := numbers(prs); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("fetched %v, want [1 2]", got)
+	}
+}
@@ -0,0 +1,137 @@
+package ghapi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// AppAuth identifies a GitHub App installation the clients authenticate as,
+// instead of using a personal access token.
+type AppAuth struct {
+	AppID          int64
+	InstallationID int64
+	// PrivateKey is the PEM encoded private key of the App.
+	PrivateKey []byte
+}
+
+// appTokenSource mints installation access tokens by exchanging a JWT signed
+// with the App private key.
+type appTokenSource struct {
+	auth    AppAuth
+	key     *rsa.PrivateKey
+	apiURL  string
+	httpCli *http.Client
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// newAppTokenSource returns a token source that keeps an installation token
+// and refreshes it shortly before it expires.
+func newAppTokenSource(auth AppAuth, opts Options) (oauth2.TokenSource, error) {
+	key, err := parsePrivateKey(auth.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %w", err)
+	}
+	apiURL := "https://api.github.com/"
+	if opts.BaseURL != "" {
+		u, err := serverURL(opts.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub API URL %q: %w", opts.BaseURL, err)
+		}
+		u.Path += "api/v3/"
+		apiURL = u.String()
+	}
+	src := &appTokenSource{
+		auth:    auth,
+		key:     key,
+		apiURL:  apiURL,
+		httpCli: &http.Client{Transport: opts.Transport},
+	}
+	return oauth2.ReuseTokenSource(nil, src), nil
+}
+
+// jwt returns a JSON Web Token identifying the App, valid for 9 minutes
+// (GitHub accepts at most 10) and backdated to absorb clock drift.
+func (s *appTokenSource) jwt() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": s.auth.AppID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+	u := fmt.Sprintf("%sapp/installations/%d/access_tokens", s.apiURL, s.auth.InstallationID)
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	resp, err := s.httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to create installation token: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode installation token: %w", err)
+	}
+	return &oauth2.Token{AccessToken: token.Token, TokenType: "token", Expiry: token.ExpiresAt}, nil
+}
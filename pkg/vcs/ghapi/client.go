@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmartin82/mkpis/pkg/vcs"
@@ -13,166 +17,430 @@ import (
 )
 
 type Client struct {
-	c   *github.Client
-	ctx context.Context
+	c              *github.Client
+	concurrency    int
+	checkRateLimit bool
+
+	rateMu sync.Mutex
+	rate   github.Rate
 }
 
-func (cli *Client) connect(accessToken string) {
+// Options configures how the clients connect to GitHub.
+type Options struct {
+	// BaseURL is the API URL of a GitHub Enterprise Server instance, e.g.
+	// https://github.example.com/api/v3/. When empty, github.com is used.
+	BaseURL string
+	// UploadURL is the upload URL of a GitHub Enterprise Server instance.
+	// When empty, it is derived from BaseURL.
+	UploadURL string
+	// App, when set, authenticates as a GitHub App installation and the
+	// access token is ignored.
+	App *AppAuth
+	// Transport sends the requests to GitHub. When nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+	// Concurrency is the number of PRs Client fetches in parallel. Values
+	// below 1 fetch them one after another.
+	Concurrency int
+	// CheckRateLimit makes the GetMergedPRList of Client fail before
+	// listing the PRs when no request is left, and while listing them as
+	// soon as the rate limit left can't cover the PRs found so far.
+	// GraphQLClient doesn't support it.
+	CheckRateLimit bool
+}
 
-	ts := oauth2.StaticTokenSource(
+func (opts Options) tokenSource(accessToken string) (oauth2.TokenSource, error) {
+	if opts.App != nil {
+		return newAppTokenSource(*opts.App, opts)
+	}
+	return oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: accessToken},
-	)
-	tc := oauth2.NewClient(cli.ctx, ts)
+	), nil
+}
 
-	cli.c = github.NewClient(tc)
+// serverURL returns the root URL of the GitHub Enterprise Server instance
+// behind the API URL base.
+func serverURL(base string) (*url.URL, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/api/v3") + "/"
+	return u, nil
 }
 
-func NewClient(accessToken string) *Client {
-	cli := &Client{}
-	cli.ctx = context.Background()
-	cli.connect(accessToken)
-	return cli
+// httpClient returns an HTTP client authenticating every request sent
+// through opts.Transport.
+func (opts Options) httpClient(accessToken string) (*http.Client, error) {
+	ts, err := opts.tokenSource(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &oauth2.Transport{Source: ts, Base: opts.Transport},
+	}, nil
 }
 
-func (cli *Client) getFirstAndLastCommitTime(owner string, repo string, prNum int) (first time.Time, last time.Time) {
-	log.Printf("Getting first and last commit from %d", prNum)
-	var commits []*github.RepositoryCommit
-	commits, resp, err := cli.c.PullRequests.ListCommits(cli.ctx, owner, repo, prNum, &github.ListOptions{PerPage: 50})
+func (cli *Client) connect(accessToken string, opts Options) error {
+
+	tc, err := opts.httpClient(accessToken)
 	if err != nil {
-		log.Printf("Error getting first commit date: %s\n", err)
-		return time.Time{}, time.Time{}
+		return err
 	}
-	fcommit := commits[0]
-	first = fcommit.GetCommit().Committer.GetDate()
-	if resp.NextPage != 0 {
-		lastCommits, _, err := cli.c.PullRequests.ListCommits(cli.ctx, owner, repo, prNum, &github.ListOptions{PerPage: 1, Page: resp.LastPage})
+
+	if opts.BaseURL == "" {
+		cli.c = github.NewClient(tc)
+		return nil
+	}
+	uploadURL := opts.UploadURL
+	if uploadURL == "" {
+		u, err := serverURL(opts.BaseURL)
 		if err != nil {
-			log.Printf("Error getting last commit date: %s\n", err)
-			return time.Time{}, time.Time{}
+			return fmt.Errorf("invalid GitHub API URL %q: %w", opts.BaseURL, err)
 		}
-		commits = lastCommits
+		uploadURL = u.String()
 	}
+	c, err := github.NewEnterpriseClient(opts.BaseURL, uploadURL, tc)
+	if err != nil {
+		return fmt.Errorf("invalid GitHub Enterprise URL: %w", err)
+	}
+	cli.c = c
+	return nil
+}
 
-	lcommit := commits[len(commits)-1]
-
-	last = lcommit.GetCommit().Committer.GetDate()
-	return
+func NewClient(accessToken string, opts Options) (*Client, error) {
+	cli := &Client{concurrency: opts.Concurrency, checkRateLimit: opts.CheckRateLimit}
+	if err := cli.connect(accessToken, opts); err != nil {
+		return nil, err
+	}
+	return cli, nil
 }
 
-func (cli *Client) getFirstAndLastReviewCommentTime(owner string, repo string, prNum int) (first time.Time, last time.Time) {
-	log.Printf("Getting first and last comment from %d", prNum)
-	var comments []*github.PullRequestReview
-	comments, resp, err := cli.c.PullRequests.ListReviews(cli.ctx, owner, repo, prNum, &github.ListOptions{PerPage: 50})
+func (cli *Client) getFirstAndLastCommitTime(ctx context.Context, owner string, repo string, prNum int) (first time.Time, last time.Time, err error) {
+	log.Printf("Getting first and last commit from %d", prNum)
+	opt := &github.ListOptions{PerPage: 50}
+	var commits []*github.RepositoryCommit
+	var resp *github.Response
+	err = cli.call(ctx, func() (*github.Response, error) {
+		var err error
+		commits, resp, err = cli.c.PullRequests.ListCommits(ctx, owner, repo, prNum, opt)
+		return resp, err
+	})
 	if err != nil {
-		log.Printf("Error getting first review date: %s\n", err)
-		return time.Time{}, time.Time{}
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to get first commit: %w", err)
 	}
-
-	if len(comments) == 0 {
-		return time.Time{}, time.Time{}
+	if len(commits) == 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("no commits listed")
 	}
+	first = commits[0].GetCommit().GetCommitter().GetDate()
+	if resp.NextPage != 0 {
+		opt.Page = resp.LastPage
+		err := cli.call(ctx, func() (*github.Response, error) {
+			var lastResp *github.Response
+			var err error
+			commits, lastResp, err = cli.c.PullRequests.ListCommits(ctx, owner, repo, prNum, opt)
+			return lastResp, err
+		})
+		if err != nil {
+			return first, time.Time{}, fmt.Errorf("failed to get last commit: %w", err)
+		}
+		if len(commits) == 0 {
+			return first, time.Time{}, fmt.Errorf("no commits listed on page %d", opt.Page)
+		}
+	}
+	last = commits[len(commits)-1].GetCommit().GetCommitter().GetDate()
+	return first, last, nil
+}
 
-	comments = removePendingReviews(comments)
-
-	fComment := comments[0]
-	first = fComment.GetSubmittedAt()
+// getReviews lists every submitted review of a PR, in submission order.
+func (cli *Client) getReviews(ctx context.Context, owner string, repo string, prNum int) ([]vcs.Review, error) {
+	log.Printf("Getting reviews from %d", prNum)
+	var reviews []vcs.Review
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		var page []*github.PullRequestReview
+		var resp *github.Response
+		err := cli.call(ctx, func() (*github.Response, error) {
+			var err error
+			page, resp, err = cli.c.PullRequests.ListReviews(ctx, owner, repo, prNum, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page {
+			// pending reviews were never submitted
+			if r.SubmittedAt == nil {
+				continue
+			}
+			reviews = append(reviews, vcs.Review{
+				Author:      r.GetUser().GetLogin(),
+				State:       r.GetState(),
+				SubmittedAt: r.GetSubmittedAt(),
+				BodyLength:  len(r.GetBody()),
+			})
+		}
+		if resp.NextPage == 0 {
+			return reviews, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
 
-	if resp.NextPage != 0 {
-		lastComments, _, err := cli.c.PullRequests.ListReviews(cli.ctx, owner, repo, prNum, &github.ListOptions{PerPage: 1, Page: resp.LastPage})
+// getComments lists the inline comments on the diff of a PR and the
+// comments on its conversation, which GitHub keeps as issue comments.
+func (cli *Client) getComments(ctx context.Context, owner string, repo string, prNum int) ([]vcs.Comment, error) {
+	log.Printf("Getting comments from %d", prNum)
+	var comments []vcs.Comment
+	opt := &github.PullRequestListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var page []*github.PullRequestComment
+		var resp *github.Response
+		err := cli.call(ctx, func() (*github.Response, error) {
+			var err error
+			page, resp, err = cli.c.PullRequests.ListComments(ctx, owner, repo, prNum, opt)
+			return resp, err
+		})
 		if err != nil {
-			log.Printf("Error getting last review date: %s\n", err)
-			return time.Time{}, time.Time{}
+			return nil, err
+		}
+		for _, c := range page {
+			comments = append(comments, vcs.Comment{Author: c.GetUser().GetLogin(), Kind: vcs.CommentReview, CreatedAt: c.GetCreatedAt()})
 		}
-		comments = lastComments
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	issueOpt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var page []*github.IssueComment
+		var resp *github.Response
+		err := cli.call(ctx, func() (*github.Response, error) {
+			var err error
+			page, resp, err = cli.c.Issues.ListComments(ctx, owner, repo, prNum, issueOpt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			comments = append(comments, vcs.Comment{Author: c.GetUser().GetLogin(), Kind: vcs.CommentConversation, CreatedAt: c.GetCreatedAt()})
+		}
+		if resp.NextPage == 0 {
+			return comments, nil
+		}
+		issueOpt.Page = resp.NextPage
 	}
+}
 
-	lComment := comments[len(comments)-1]
+// issueEvent is an event of the PR timeline. go-github doesn't decode the
+// reviewer of review request events.
+type issueEvent struct {
+	Event             string       `json:"event"`
+	CreatedAt         time.Time    `json:"created_at"`
+	RequestedReviewer *github.User `json:"requested_reviewer"`
+}
 
-	last = lComment.GetSubmittedAt()
-	return
+// timeline is what the events of a PR tell about its review requests and
+// its drafts.
+type timeline struct {
+	requests []vcs.ReviewRequest
+	// firstDraftEvent is the first ready_for_review or convert_to_draft
+	// event: a PR first marked as ready for review was opened as a draft.
+	firstDraftEvent *issueEvent
 }
 
-func removePendingReviews(comments []*github.PullRequestReview) []*github.PullRequestReview {
-	var filtered []*github.PullRequestReview
-	for _, c := range comments {
-		if c.SubmittedAt != nil || *c.State != "PENDING" {
-			filtered = append(filtered, c)
+// getTimeline lists the review requests of a PR made to users and its first
+// draft event. Team requests can't be matched with the reviews, so they are
+// skipped.
+func (cli *Client) getTimeline(ctx context.Context, owner string, repo string, prNum int) (timeline, error) {
+	log.Printf("Getting events from %d", prNum)
+	var tl timeline
+	for page := 1; page != 0; {
+		req, err := cli.c.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/issues/%d/events?per_page=100&page=%d", owner, repo, prNum, page), nil)
+		if err != nil {
+			return timeline{}, err
 		}
+		var events []issueEvent
+		var resp *github.Response
+		err = cli.call(ctx, func() (*github.Response, error) {
+			var err error
+			resp, err = cli.c.Do(ctx, req, &events)
+			return resp, err
+		})
+		if err != nil {
+			return timeline{}, err
+		}
+		for i, e := range events {
+			switch {
+			case e.Event == "ready_for_review" || e.Event == "convert_to_draft":
+				if tl.firstDraftEvent == nil {
+					tl.firstDraftEvent = &events[i]
+				}
+			case e.RequestedReviewer == nil:
+			case e.Event == "review_requested":
+				tl.requests = append(tl.requests, vcs.ReviewRequest{Reviewer: e.RequestedReviewer.GetLogin(), RequestedAt: e.CreatedAt})
+			case e.Event == "review_request_removed":
+				reviewer := e.RequestedReviewer.GetLogin()
+				for j := len(tl.requests) - 1; j >= 0; j-- {
+					if tl.requests[j].Reviewer == reviewer && tl.requests[j].RemovedAt.IsZero() {
+						tl.requests[j].RemovedAt = e.CreatedAt
+						break
+					}
+				}
+			}
+		}
+		page = resp.NextPage
 	}
-	return filtered
+	return tl, nil
 }
 
-func (cli *Client) GetMergedPRList(owner string, repo string, from time.Time, to time.Time, base string) ([]vcs.PR, error) {
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	if cli.checkRateLimit {
+		if err := cli.checkListBudget(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-	if _, _, err := cli.c.Repositories.GetBranch(cli.ctx, owner, repo, base); err != nil {
-		return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+	base, single := vcs.SingleBase(bases)
+	if single {
+		err := cli.call(ctx, func() (*github.Response, error) {
+			_, resp, err := cli.c.Repositories.GetBranch(ctx, owner, repo, base)
+			return resp, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
 	}
 
+	// PRs are listed by last update: merging updates a PR, so once a PR
+	// updated before from shows up, no later page can hold a PR merged in
+	// the window, however long ago it was created.
 	var pRNums []int
-	var pRList []vcs.PR
-	opt := &github.PullRequestListOptions{State: "closed", Base: base /*"devel"*/}
+	opt := &github.PullRequestListOptions{State: "closed", Base: base, Sort: "updated", Direction: "desc"}
 	opt.PerPage = 100
-	log.Printf("Fetching Closed PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	log.Printf("Fetching Merged PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
 pagination:
 	for {
 
-		prs, resp, err := cli.c.PullRequests.List(cli.ctx, owner, repo, opt)
+		var prs []*github.PullRequest
+		var resp *github.Response
+		err := cli.call(ctx, func() (*github.Response, error) {
+			var err error
+			prs, resp, err = cli.c.PullRequests.List(ctx, owner, repo, opt)
+			return resp, err
+		})
 		if err != nil {
 			return nil, err
 		}
 
 		for _, pr := range prs {
-			if pr.ClosedAt.Before(from) {
+			if pr.GetUpdatedAt().Before(from) {
 				log.Printf("Discarded PR: %d out of the date range", pr.GetNumber())
 				//shortcut to avoid more call I know I don't need the rest
 				break pagination
 			}
 
-			if pr.GetMergedAt().IsZero() || pr.GetClosedAt().After(to) {
+			mergedAt := pr.GetMergedAt()
+			if mergedAt.IsZero() || mergedAt.Before(from) || mergedAt.After(to) {
+				continue
+			}
+			if !vcs.MatchBase(bases, pr.GetBase().GetRef()) {
 				continue
 			}
 
 			pRNums = append(pRNums, pr.GetNumber())
 		}
+		if cli.checkRateLimit {
+			if err := checkBudget(len(pRNums), resp.Rate); err != nil {
+				return nil, err
+			}
+		}
 		if resp.NextPage == 0 {
 			break
 		}
 
 		opt.Page = resp.NextPage
 	}
-	for _, prNum := range pRNums {
-		pr, err := cli.GetPRInfo(owner, repo, prNum)
-		if err != nil {
-			return nil, err
-		}
-		pRList = append(pRList, pr)
-	}
-	return pRList, nil
+	cli.logRate()
+	pRList, err := vcs.FetchPRs(ctx, pRNums, cli.concurrency, func(ctx context.Context, prNum int) (vcs.PR, error) {
+		return cli.GetPRInfo(ctx, owner, repo, prNum)
+	})
+	cli.logRate()
+	return pRList, err
 }
 
-func (cli *Client) GetPRInfo(owner, repo string, prNum int) (vcs.PR, error) {
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
 	log.Printf("Fetching info for PR %d", prNum)
-	pr, _, err := cli.c.PullRequests.Get(cli.ctx, owner, repo, prNum)
+	var pr *github.PullRequest
+	err := cli.call(ctx, func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		pr, resp, err = cli.c.PullRequests.Get(ctx, owner, repo, prNum)
+		return resp, err
+	})
 	if err != nil {
 		return vcs.PR{}, err
 	}
 
-	fc, lc := cli.getFirstAndLastCommitTime(owner, repo, pr.GetNumber())
-	fr, lr := cli.getFirstAndLastReviewCommentTime(owner, repo, pr.GetNumber())
+	var warnings []string
+	fc, lc, err := cli.getFirstAndLastCommitTime(ctx, owner, repo, pr.GetNumber())
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("commit times: %s", err))
+	}
+	reviews, err := cli.getReviews(ctx, owner, repo, pr.GetNumber())
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("reviews: %s", err))
+	}
+	comments, err := cli.getComments(ctx, owner, repo, pr.GetNumber())
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("comments: %s", err))
+	}
+	tl, err := cli.getTimeline(ctx, owner, repo, pr.GetNumber())
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("events: %s", err))
+	}
+	var labels []string
+	for _, l := range pr.Labels {
+		labels = append(labels, l.GetName())
+	}
+	draft, readyAt := pr.GetDraft(), time.Time{}
+	if e := tl.firstDraftEvent; e != nil {
+		draft = e.Event == "ready_for_review"
+		if draft {
+			readyAt = e.CreatedAt
+		}
+	}
+	if ctx.Err() != nil {
+		return vcs.PR{}, ctx.Err()
+	}
+	for _, w := range warnings {
+		log.Printf("Warning on PR %d: %s", prNum, w)
+	}
+	fr, lr := vcs.ReviewSpan(reviews)
 	return vcs.PR{
-		Number:         pr.GetNumber(),
-		Creator:        pr.GetUser().GetLogin(),
-		CreatedAt:      pr.GetCreatedAt(),
-		MergedAt:       pr.GetMergedAt(),
-		ChangedFiles:   pr.GetChangedFiles(),
-		ChangedLines:   pr.GetDeletions() + pr.GetAdditions(),
-		ReviewComments: pr.GetReviewComments(),
-		Base:           pr.GetBase().GetRef(),
-		Head:           pr.GetHead().GetSHA(),
-		Commits:        pr.GetCommits(),
-		FirstCommitAt:  fc,
-		LastCommitAt:   lc,
-		FirstCommentAt: fr,
-		LastCommentAt:  lr,
+		Number:           pr.GetNumber(),
+		Creator:          pr.GetUser().GetLogin(),
+		CreatedAt:        pr.GetCreatedAt(),
+		MergedAt:         pr.GetMergedAt(),
+		ChangedFiles:     pr.GetChangedFiles(),
+		ChangedLines:     pr.GetDeletions() + pr.GetAdditions(),
+		ReviewComments:   pr.GetReviewComments(),
+		Base:             pr.GetBase().GetRef(),
+		Head:             pr.GetHead().GetSHA(),
+		Commits:          pr.GetCommits(),
+		FirstCommitAt:    fc,
+		LastCommitAt:     lc,
+		FirstCommentAt:   fr,
+		LastCommentAt:    lr,
+		Reviews:          reviews,
+		ReviewRequests:   tl.requests,
+		Comments:         comments,
+		Draft:            draft,
+		ReadyForReviewAt: readyAt,
+		Labels:           labels,
+		Milestone:        pr.GetMilestone().GetTitle(),
+		Warnings:         warnings,
 	}, nil
 }
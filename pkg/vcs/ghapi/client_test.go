@@ -0,0 +1,212 @@
+package ghapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitHub answers the GitHub API requests with the JSON body of their
+// path and records the requested paths. Unknown paths fail the test.
+type fakeGitHub struct {
+	t         *testing.T
+	mu        sync.Mutex
+	responses map[string]interface{}
+	header    http.Header
+	requested []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requested = append(f.requested, r.URL.Path)
+	body, ok := f.responses[r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL.RequestURI())
+		http.NotFound(w, r)
+		return
+	}
+	for k, v := range f.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// TestEnterpriseURLs checks that the REST, upload and GraphQL URLs of a
+// GitHub Enterprise Server are derived from its API URL.
+func TestEnterpriseURLs(t *testing.T) {
+	for _, suffix := range []string{"/api/v3/", "/api/v3", ""} {
+		t.Run("BaseURL"+suffix, func(t *testing.T) {
+			fake := &fakeGitHub{t: t, responses: map[string]interface{}{
+				"/api/v3/repos/owner/repo/branches/master": map[string]string{"name": "master"},
+				"/api/v3/repos/owner/repo/pulls":           []interface{}{},
+				"/api/graphql": map[string]interface{}{"data": map[string]interface{}{
+					"repository": map[string]interface{}{"pullRequest": map[string]interface{}{"number": 1}},
+				}},
+			}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			opts := Options{BaseURL: srv.URL + suffix}
+
+			cli, err := NewClient("token", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := cli.c.UploadURL.String(), srv.URL+"/api/uploads/"; got != want {
+				t.Errorf("upload URL = %s, want %s", got, want)
+			}
+			from := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+			if _, err := cli.GetMergedPRList(context.Background(), "owner", "repo", from, from.AddDate(0, 1, 0), []string{"master"}); err != nil {
+				t.Fatal(err)
+			}
+
+			gql, err := NewGraphQLClient("token", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := gql.GetPRInfo(context.Background(), "owner", "repo", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "/api/v3/repos/owner/repo/branches/master,/api/v3/repos/owner/repo/pulls,/api/graphql"
+			if got := strings.Join(fake.requested, ","); got != want {
+				t.Errorf("requested %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	from := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	merged := func(n int) map[string]interface{} {
+		return map[string]interface{}{"number": n, "updated_at": from.Add(time.Hour), "merged_at": from.Add(time.Hour), "base": map[string]string{"ref": "master"}}
+	}
+	rateLimit := func(remaining int) map[string]interface{} {
+		return map[string]interface{}{"resources": map[string]interface{}{"core": map[string]int{"limit": 5000, "remaining": remaining}}}
+	}
+	tests := []struct {
+		name      string
+		remaining int // in the rate limit response
+		listLeft  string
+		requested string
+	}{
+		{"none left before listing", 0, "5000", "/api/v3/rate_limit"},
+		{"too few left after a page", 10, "10", "/api/v3/rate_limit,/api/v3/repos/owner/repo/pulls"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGitHub{t: t, responses: map[string]interface{}{
+				"/api/v3/rate_limit":             rateLimit(tt.remaining),
+				"/api/v3/repos/owner/repo/pulls": []interface{}{merged(2), merged(1)},
+			}, header: http.Header{"X-Ratelimit-Limit": {"5000"}, "X-Ratelimit-Remaining": {tt.listLeft}}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			cli, err := NewClient("token", Options{BaseURL: srv.URL + "/api/v3/", CheckRateLimit: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cli.GetMergedPRList(context.Background(), "owner", "repo", from, from.AddDate(0, 1, 0), []string{"*"}); err == nil {
+				t.Error("listed the PRs without enough requests left")
+			}
+			if got := strings.Join(fake.requested, ","); got != tt.requested {
+				t.Errorf("requested %s, want %s", got, tt.requested)
+			}
+		})
+	}
+
+	if _, err := NewGraphQLClient("token", Options{CheckRateLimit: true}); err == nil {
+		t.Error("GraphQL client accepted CheckRateLimit")
+	}
+}
+
+// TestGetMergedPRList checks that the PRs are selected by merge date while
+// paging the closed PRs by last update, down to the first one updated before
+// the window.
+func TestGetMergedPRList(t *testing.T) {
+	from := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 8, 31, 0, 0, 0, 0, time.UTC)
+	day := func(month time.Month, d int) time.Time { return time.Date(2020, month, d, 12, 0, 0, 0, time.UTC) }
+	pr := func(n int, created, updated time.Time, merged *time.Time) map[string]interface{} {
+		return map[string]interface{}{"number": n, "created_at": created, "updated_at": updated, "closed_at": updated, "merged_at": merged, "base": map[string]string{"ref": "master"}}
+	}
+	merged := func(at time.Time) *time.Time { return &at }
+	pages := [][]interface{}{
+		{
+			pr(6, day(8, 20), day(9, 3), merged(day(9, 2))),  // merged after the window
+			pr(5, day(8, 10), day(9, 2), merged(day(8, 20))), // updated after its merge in the window
+			pr(4, day(7, 1), day(8, 10), merged(day(8, 10))), // created before the window
+			pr(3, day(7, 28), day(8, 5), nil),                // closed without merging
+			pr(2, day(7, 25), day(8, 3), merged(day(7, 30))), // merged before, updated in the window
+		},
+		{
+			pr(1, day(7, 10), day(7, 20), merged(day(7, 20))), // updated before the window
+			pr(0, day(7, 1), day(8, 2), merged(day(8, 2))),    // never reached
+		},
+		{
+			pr(7, day(7, 1), day(8, 2), merged(day(8, 2))),
+		},
+	}
+
+	fake := &fakeGitHub{t: t, responses: map[string]interface{}{}}
+	for _, n := range []int{4, 5} {
+		pulls := fmt.Sprintf("/api/v3/repos/owner/repo/pulls/%d", n)
+		issues := fmt.Sprintf("/api/v3/repos/owner/repo/issues/%d", n)
+		fake.responses[pulls] = pages[0][6-n]
+		fake.responses[pulls+"/commits"] = []interface{}{map[string]interface{}{"commit": map[string]interface{}{"committer": map[string]interface{}{"date": day(8, 1)}}}}
+		fake.responses[pulls+"/reviews"] = []interface{}{}
+		fake.responses[pulls+"/comments"] = []interface{}{}
+		fake.responses[issues+"/comments"] = []interface{}{}
+		fake.responses[issues+"/events"] = []interface{}{}
+	}
+	var listed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/owner/repo/pulls" {
+			fake.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("state") != "closed" || q.Get("sort") != "updated" || q.Get("direction") != "desc" {
+			t.Errorf("listed PRs with %s, want the closed ones by last update", r.URL.RawQuery)
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		listed = append(listed, strconv.Itoa(page))
+		if page < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next"`, "http://"+r.Host, r.URL.Path, page+1))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pages[page-1])
+	}))
+	defer srv.Close()
+
+	cli, err := NewClient("token", Options{BaseURL: srv.URL + "/api/v3/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prs, err := cli.GetMergedPRList(context.Background(), "owner", "repo", from, to, []string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, pr := range prs {
+		got = append(got, pr.Number)
+	}
+	if !reflect.DeepEqual(got, []int{5, 4}) {
+		t.Errorf("listed PRs %v, want [5 4]", got)
+	}
+	if strings.Join(listed, ",") != "1,2" {
+		t.Errorf("listed pages %v, want 1 and 2", listed)
+	}
+}
@@ -0,0 +1,538 @@
+package ghapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+const graphQLEndpoint = "https://api.github.com/graphql"
+
+// prFields are the PR fields needed to build a vcs.PR in a single request.
+// The first and last commit are fetched through aliases instead of listing
+// them all, as are the first 100 reviews; PRs with more reviews page the
+// rest with reviewsQuery.
+const prFields = `
+fragment prFields on PullRequest {
+  number
+  author { __typename login }
+  createdAt
+  mergedAt
+  baseRefName
+  headRefOid
+  changedFiles
+  additions
+  deletions
+  commits { totalCount }
+  firstCommit: commits(first: 1) { nodes { commit { committedDate } } }
+  lastCommit: commits(last: 1) { nodes { commit { committedDate } } }
+  reviews(first: 100, states: [APPROVED, CHANGES_REQUESTED, COMMENTED, DISMISSED]) { ...reviewFields }
+  reviewRequests: timelineItems(first: 100, itemTypes: [REVIEW_REQUESTED_EVENT, REVIEW_REQUEST_REMOVED_EVENT]) {
+    pageInfo { hasNextPage }
+    nodes {
+      __typename
+      ... on ReviewRequestedEvent { createdAt requestedReviewer { ... on User { login } } }
+      ... on ReviewRequestRemovedEvent { createdAt requestedReviewer { ... on User { login } } }
+    }
+  }
+  comments(first: 100) {
+    pageInfo { hasNextPage }
+    nodes { author { __typename login } createdAt }
+  }
+  labels(first: 100) { nodes { name } }
+  milestone { title }
+  isDraft
+  firstDraftEvent: timelineItems(first: 1, itemTypes: [READY_FOR_REVIEW_EVENT, CONVERT_TO_DRAFT_EVENT]) {
+    nodes {
+      __typename
+      ... on ReadyForReviewEvent { createdAt }
+      ... on ConvertToDraftEvent { createdAt }
+    }
+  }
+}` + reviewFields
+
+// reviewFields are the review fields needed to build a vcs.Review.
+const reviewFields = `
+fragment reviewFields on PullRequestReviewConnection {
+  pageInfo { hasNextPage endCursor }
+  nodes {
+    author { __typename login }
+    state
+    submittedAt
+    body
+    comments { totalCount }
+  }
+}`
+
+const searchQuery = `
+query($q: String!, $cursor: String) {
+  rateLimit { cost remaining }
+  search(query: $q, type: ISSUE, first: 25, after: $cursor) {
+    issueCount
+    pageInfo { hasNextPage endCursor }
+    nodes { ...prFields }
+  }
+}` + prFields
+
+const pullRequestQuery = `
+query($owner: String!, $repo: String!, $number: Int!) {
+  rateLimit { cost remaining }
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) { ...prFields }
+  }
+}` + prFields
+
+const reviewsQuery = `
+query($owner: String!, $repo: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviews(first: 100, after: $cursor, states: [APPROVED, CHANGES_REQUESTED, COMMENTED, DISMISSED]) { ...reviewFields }
+    }
+  }
+}` + reviewFields
+
+const branchQuery = `
+query($owner: String!, $repo: String!, $ref: String!) {
+  repository(owner: $owner, name: $repo) {
+    ref(qualifiedName: $ref) { name }
+  }
+}`
+
+// GraphQLClient implements vcs.Client on top of the GitHub GraphQL API (v4).
+// It fetches every PR with its commits and reviews in one query, and the
+// merged PR list in pages of 25, instead of the several REST calls per PR
+// made by Client.
+type GraphQLClient struct {
+	c        *http.Client
+	endpoint string
+}
+
+type commitNodes struct {
+	Nodes []struct {
+		Commit struct {
+			CommittedDate time.Time `json:"committedDate"`
+		} `json:"commit"`
+	} `json:"nodes"`
+}
+
+// actor is the author of a PR, review or comment. Unlike in the REST API,
+// the logins of bots have no [bot] suffix.
+type actor struct {
+	Typename string `json:"__typename"`
+	Login    string `json:"login"`
+}
+
+// login returns the login of a, as the REST API would.
+func (a *actor) login() string {
+	switch {
+	case a == nil:
+		return ""
+	case a.Typename == "Bot":
+		return a.Login + "[bot]"
+	}
+	return a.Login
+}
+
+type reviewNodes struct {
+	PageInfo pageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Author      *actor    `json:"author"`
+		State       string    `json:"state"`
+		SubmittedAt time.Time `json:"submittedAt"`
+		Body        string    `json:"body"`
+		Comments    struct {
+			TotalCount int `json:"totalCount"`
+		} `json:"comments"`
+	} `json:"nodes"`
+}
+
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type graphQLPR struct {
+	Number       int       `json:"number"`
+	Author       *actor    `json:"author"`
+	CreatedAt    time.Time `json:"createdAt"`
+	MergedAt     time.Time `json:"mergedAt"`
+	BaseRefName  string    `json:"baseRefName"`
+	HeadRefOid   string    `json:"headRefOid"`
+	ChangedFiles int       `json:"changedFiles"`
+	Additions    int       `json:"additions"`
+	Deletions    int       `json:"deletions"`
+	Commits      struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"commits"`
+	FirstCommit commitNodes `json:"firstCommit"`
+	LastCommit  commitNodes `json:"lastCommit"`
+	Reviews     reviewNodes `json:"reviews"`
+	// ReviewRequests are limited to the first 100 request events.
+	ReviewRequests struct {
+		PageInfo pageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Typename          string    `json:"__typename"`
+			CreatedAt         time.Time `json:"createdAt"`
+			RequestedReviewer *struct {
+				Login string `json:"login"`
+			} `json:"requestedReviewer"`
+		} `json:"nodes"`
+	} `json:"reviewRequests"`
+	// Comments are limited to the first 100 conversation comments.
+	Comments struct {
+		PageInfo pageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Author    *actor    `json:"author"`
+			CreatedAt time.Time `json:"createdAt"`
+		} `json:"nodes"`
+	} `json:"comments"`
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Milestone *struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+	IsDraft bool `json:"isDraft"`
+	// FirstDraftEvent tells whether the PR was opened as a draft: it was
+	// when it was first marked as ready for review.
+	FirstDraftEvent struct {
+		Nodes []struct {
+			Typename  string    `json:"__typename"`
+			CreatedAt time.Time `json:"createdAt"`
+		} `json:"nodes"`
+	} `json:"firstDraftEvent"`
+}
+
+type rateLimit struct {
+	Cost      int `json:"cost"`
+	Remaining int `json:"remaining"`
+}
+
+// NewGraphQLClient returns a GraphQLClient. For GitHub Enterprise Server,
+// the GraphQL endpoint is derived from opts.BaseURL.
+func NewGraphQLClient(accessToken string, opts Options) (*GraphQLClient, error) {
+	if opts.CheckRateLimit {
+		return nil, fmt.Errorf("checking the rate limit is not supported by the GraphQL client")
+	}
+	cli := &GraphQLClient{endpoint: graphQLEndpoint}
+	c, err := opts.httpClient(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	cli.c = c
+
+	if opts.BaseURL != "" {
+		u, err := serverURL(opts.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub API URL %q: %w", opts.BaseURL, err)
+		}
+		u.Path += "api/graphql"
+		cli.endpoint = u.String()
+	}
+	return cli, nil
+}
+
+// query runs a GraphQL query and decodes its data into v. Rate limited
+// queries are retried like in Client.call: after the primary limit resets,
+// or backing off at most maxAbuseRetries times after a secondary limit.
+func (cli *GraphQLClient) query(ctx context.Context, query string, variables map[string]interface{}, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	for retries := 0; ; retries++ {
+		data, header, limited, err := cli.post(ctx, body)
+		primary := limited && header.Get("Retry-After") == "" && header.Get("X-RateLimit-Remaining") == "0" && header.Get("X-RateLimit-Reset") != ""
+		if !limited || (!primary && retries >= maxAbuseRetries) {
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(data, v)
+		}
+		wait := rateLimitWait(header, retries)
+		log.Printf("GraphQL rate limit hit, retrying in %s", wait.Round(time.Second))
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// post sends a GraphQL request and reports whether it was rate limited.
+func (cli *GraphQLClient) post(ctx context.Context, body []byte) (data json.RawMessage, header http.Header, limited bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cli.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := cli.c.Do(req)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		limited = resp.StatusCode == http.StatusTooManyRequests ||
+			(resp.StatusCode == http.StatusForbidden && (resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"))
+		return nil, resp.Header, limited, fmt.Errorf("POST %s: %s", cli.endpoint, resp.Status)
+	}
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to decode GraphQL response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		msgs := make([]string, len(result.Errors))
+		for i, e := range result.Errors {
+			msgs[i] = e.Message
+			limited = limited || e.Type == "RATE_LIMITED"
+		}
+		return nil, resp.Header, limited, fmt.Errorf("GraphQL query failed: %s", strings.Join(msgs, "; "))
+	}
+	return result.Data, resp.Header, false, nil
+}
+
+// add appends the reviews of nodes and their inline comments. The comments
+// are only counted, so they are attributed to the review author at
+// submission time, when they are published.
+func (nodes reviewNodes) add(reviews []vcs.Review, comments []vcs.Comment) ([]vcs.Review, []vcs.Comment) {
+	for _, r := range nodes.Nodes {
+		author := r.Author.login()
+		reviews = append(reviews, vcs.Review{
+			Author:      author,
+			State:       r.State,
+			SubmittedAt: r.SubmittedAt,
+			BodyLength:  len(r.Body),
+		})
+		for i := 0; i < r.Comments.TotalCount; i++ {
+			comments = append(comments, vcs.Comment{Author: author, Kind: vcs.CommentReview, CreatedAt: r.SubmittedAt})
+		}
+	}
+	return reviews, comments
+}
+
+// toPR builds the vcs.PR of pr. Its reviews beyond the first 100 are
+// fetched with reviewsQuery.
+func (cli *GraphQLClient) toPR(ctx context.Context, owner, repo string, pr graphQLPR) (vcs.PR, error) {
+	var fc, lc time.Time
+	var warnings []string
+	if len(pr.FirstCommit.Nodes) > 0 && len(pr.LastCommit.Nodes) > 0 {
+		fc = pr.FirstCommit.Nodes[0].Commit.CommittedDate
+		lc = pr.LastCommit.Nodes[0].Commit.CommittedDate
+	} else {
+		warnings = append(warnings, "commit times: no commits listed")
+	}
+	reviews, comments := pr.Reviews.add(nil, nil)
+	for page := pr.Reviews.PageInfo; page.HasNextPage; {
+		var result struct {
+			Repository struct {
+				PullRequest struct {
+					Reviews reviewNodes `json:"reviews"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		variables := map[string]interface{}{"owner": owner, "repo": repo, "number": pr.Number, "cursor": page.EndCursor}
+		if err := cli.query(ctx, reviewsQuery, variables, &result); err != nil {
+			return vcs.PR{}, fmt.Errorf("failed to get reviews of PR %d: %w", pr.Number, err)
+		}
+		next := result.Repository.PullRequest.Reviews
+		reviews, comments = next.add(reviews, comments)
+		page = next.PageInfo
+	}
+	reviewComments := len(comments)
+	for _, c := range pr.Comments.Nodes {
+		comments = append(comments, vcs.Comment{Author: c.Author.login(), Kind: vcs.CommentConversation, CreatedAt: c.CreatedAt})
+	}
+	if pr.Comments.PageInfo.HasNextPage {
+		warnings = append(warnings, "comments: only the first 100 conversation comments were fetched")
+	}
+	fr, lr := vcs.ReviewSpan(reviews)
+
+	var requests []vcs.ReviewRequest
+	for _, e := range pr.ReviewRequests.Nodes {
+		// team requests have no login and can't be matched with the reviews
+		if e.RequestedReviewer == nil || e.RequestedReviewer.Login == "" {
+			continue
+		}
+		reviewer := e.RequestedReviewer.Login
+		if e.Typename == "ReviewRequestedEvent" {
+			requests = append(requests, vcs.ReviewRequest{Reviewer: reviewer, RequestedAt: e.CreatedAt})
+			continue
+		}
+		for i := len(requests) - 1; i >= 0; i-- {
+			if requests[i].Reviewer == reviewer && requests[i].RemovedAt.IsZero() {
+				requests[i].RemovedAt = e.CreatedAt
+				break
+			}
+		}
+	}
+	if pr.ReviewRequests.PageInfo.HasNextPage {
+		warnings = append(warnings, "review requests: only the first 100 events were fetched")
+	}
+	draft, readyAt := pr.IsDraft, time.Time{}
+	if len(pr.FirstDraftEvent.Nodes) > 0 {
+		e := pr.FirstDraftEvent.Nodes[0]
+		draft = e.Typename == "ReadyForReviewEvent"
+		if draft {
+			readyAt = e.CreatedAt
+		}
+	}
+	for _, w := range warnings {
+		log.Printf("Warning on PR %d: %s", pr.Number, w)
+	}
+	var labels []string
+	for _, l := range pr.Labels.Nodes {
+		labels = append(labels, l.Name)
+	}
+	milestone := ""
+	if pr.Milestone != nil {
+		milestone = pr.Milestone.Title
+	}
+	return vcs.PR{
+		Number:           pr.Number,
+		Creator:          pr.Author.login(),
+		CreatedAt:        pr.CreatedAt,
+		MergedAt:         pr.MergedAt,
+		ChangedFiles:     pr.ChangedFiles,
+		ChangedLines:     pr.Additions + pr.Deletions,
+		ReviewComments:   reviewComments,
+		Base:             pr.BaseRefName,
+		Head:             pr.HeadRefOid,
+		Commits:          pr.Commits.TotalCount,
+		FirstCommitAt:    fc,
+		LastCommitAt:     lc,
+		FirstCommentAt:   fr,
+		LastCommentAt:    lr,
+		Reviews:          reviews,
+		ReviewRequests:   requests,
+		Comments:         comments,
+		Draft:            draft,
+		ReadyForReviewAt: readyAt,
+		Labels:           labels,
+		Milestone:        milestone,
+		Warnings:         warnings,
+	}, nil
+}
+
+// searchLimit is the number of results the search API returns at most per
+// query.
+const searchLimit = 1000
+
+// GetMergedPRList selects the PRs with the search API. The merge date range
+// is split in halves while it matches more PRs than the search API returns.
+func (cli *GraphQLClient) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	q := fmt.Sprintf("repo:%s/%s is:pr is:merged", owner, repo)
+	if base, single := vcs.SingleBase(bases); single {
+		var branch struct {
+			Repository struct {
+				Ref *struct {
+					Name string `json:"name"`
+				} `json:"ref"`
+			} `json:"repository"`
+		}
+		err := cli.query(ctx, branchQuery, map[string]interface{}{"owner": owner, "repo": repo, "ref": "refs/heads/" + base}, &branch)
+		if err == nil && branch.Repository.Ref == nil {
+			err = fmt.Errorf("not found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		q += " base:" + base
+	}
+
+	log.Printf("Fetching Merged PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	return cli.searchMerged(ctx, owner, repo, q, from, to, bases)
+}
+
+// searchMerged fetches the PRs matching q merged between from and to, both
+// included at the second.
+func (cli *GraphQLClient) searchMerged(ctx context.Context, owner, repo, q string, from, to time.Time, bases []string) ([]vcs.PR, error) {
+	var pRList []vcs.PR
+	variables := map[string]interface{}{
+		"q": fmt.Sprintf("%s merged:%s..%s sort:created-desc", q, from.Format(time.RFC3339), to.Format(time.RFC3339)),
+	}
+	for {
+		var result struct {
+			RateLimit rateLimit `json:"rateLimit"`
+			Search    struct {
+				IssueCount int         `json:"issueCount"`
+				PageInfo   pageInfo    `json:"pageInfo"`
+				Nodes      []graphQLPR `json:"nodes"`
+			} `json:"search"`
+		}
+		if err := cli.query(ctx, searchQuery, variables, &result); err != nil {
+			if ctx.Err() != nil {
+				return pRList, ctx.Err()
+			}
+			return nil, err
+		}
+		if _, paging := variables["cursor"]; !paging && result.Search.IssueCount > searchLimit {
+			from, to := from.Truncate(time.Second), to.Truncate(time.Second)
+			if to.Sub(from) >= 2*time.Second {
+				log.Printf("%d PRs merged from %s to %s, over the %d results of a search: splitting the range",
+					result.Search.IssueCount, from.Format(time.RFC3339), to.Format(time.RFC3339), searchLimit)
+				mid := from.Add(to.Sub(from) / 2).Truncate(time.Second)
+				first, err := cli.searchMerged(ctx, owner, repo, q, from, mid, bases)
+				if err != nil {
+					return first, err
+				}
+				second, err := cli.searchMerged(ctx, owner, repo, q, mid.Add(time.Second), to, bases)
+				if err != nil && ctx.Err() == nil {
+					return nil, err
+				}
+				return append(first, second...), err
+			}
+			log.Printf("Warning: %d PRs merged at %s, only the first %d are listed",
+				result.Search.IssueCount, from.Format(time.RFC3339), searchLimit)
+		}
+		log.Printf("Fetched %d PRs (cost: %d, remaining: %d)", len(result.Search.Nodes), result.RateLimit.Cost, result.RateLimit.Remaining)
+		for _, node := range result.Search.Nodes {
+			if !vcs.MatchBase(bases, node.BaseRefName) {
+				continue
+			}
+			pr, err := cli.toPR(ctx, owner, repo, node)
+			if err != nil {
+				if ctx.Err() != nil {
+					return pRList, ctx.Err()
+				}
+				return nil, err
+			}
+			pRList = append(pRList, pr)
+		}
+		if !result.Search.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = result.Search.PageInfo.EndCursor
+	}
+	return pRList, nil
+}
+
+func (cli *GraphQLClient) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for PR %d", prNum)
+	var result struct {
+		Repository struct {
+			PullRequest *graphQLPR `json:"pullRequest"`
+		} `json:"repository"`
+	}
+	if err := cli.query(ctx, pullRequestQuery, map[string]interface{}{"owner": owner, "repo": repo, "number": prNum}, &result); err != nil {
+		return vcs.PR{}, err
+	}
+	if result.Repository.PullRequest == nil {
+		return vcs.PR{}, fmt.Errorf("PR %d not found", prNum)
+	}
+	return cli.toPR(ctx, owner, repo, *result.Repository.PullRequest)
+}
@@ -0,0 +1,167 @@
+package ghapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// TestGraphQLGetMergedPRListSplitsSearch checks that a merge date range
+// matching more PRs than a search returns is split until every PR is listed.
+func TestGraphQLGetMergedPRListSplitsSearch(t *testing.T) {
+	const total = 2500
+	start := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	mergedAt := func(n int) time.Time { return start.Add(time.Duration(n) * time.Minute) }
+	merged := regexp.MustCompile(`merged:(\S+)\.\.(\S+)`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/graphql" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Variables struct {
+				Q      string `json:"q"`
+				Cursor string `json:"cursor"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		m := merged.FindStringSubmatch(req.Variables.Q)
+		if m == nil {
+			t.Errorf("query without merge range: %s", req.Variables.Q)
+			return
+		}
+		from, _ := time.Parse(time.RFC3339, m[1])
+		to, _ := time.Parse(time.RFC3339, m[2])
+		var matches []int
+		for n := 1; n <= total; n++ {
+			if at := mergedAt(n); !at.Before(from) && !at.After(to) {
+				matches = append(matches, n)
+			}
+		}
+		count := len(matches)
+		if len(matches) > searchLimit {
+			matches = matches[:searchLimit]
+		}
+		offset, _ := strconv.Atoi(req.Variables.Cursor)
+		end := offset + 25
+		if end > len(matches) {
+			end = len(matches)
+		}
+		var nodes []interface{}
+		for _, n := range matches[offset:end] {
+			nodes = append(nodes, map[string]interface{}{"number": n, "mergedAt": mergedAt(n), "baseRefName": "master"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"search": map[string]interface{}{
+			"issueCount": count,
+			"pageInfo":   map[string]interface{}{"hasNextPage": end < len(matches), "endCursor": strconv.Itoa(end)},
+			"nodes":      nodes,
+		}}})
+	}))
+	defer srv.Close()
+
+	cli, err := NewGraphQLClient("token", Options{BaseURL: srv.URL + "/api/v3/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prs, err := cli.GetMergedPRList(context.Background(), "owner", "repo", start, mergedAt(total), []string{vcs.AllBases})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, pr := range prs {
+		got = append(got, pr.Number)
+	}
+	sort.Ints(got)
+	if len(got) != total {
+		t.Fatalf("got %d PRs, want %d", len(got), total)
+	}
+	for i, n := range got {
+		if n != i+1 {
+			t.Fatalf("PR %d listed at %d, want every PR from 1 to %d once", n, i, total)
+		}
+	}
+}
+
+// TestCreatorOfBots checks that the REST and GraphQL clients agree on the
+// login of a PR opened by a bot, which GraphQL returns without its suffix.
+func TestCreatorOfBots(t *testing.T) {
+	created := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	pulls := "/api/v3/repos/owner/repo/pulls/1"
+	issues := "/api/v3/repos/owner/repo/issues/1"
+	fake := &fakeGitHub{t: t, responses: map[string]interface{}{
+		pulls:                map[string]interface{}{"number": 1, "user": map[string]string{"login": "dependabot[bot]", "type": "Bot"}, "created_at": created, "merged_at": created.Add(time.Hour), "base": map[string]string{"ref": "master"}},
+		pulls + "/commits":   []interface{}{},
+		pulls + "/reviews":   []interface{}{},
+		pulls + "/comments":  []interface{}{},
+		issues + "/comments": []interface{}{},
+		issues + "/events":   []interface{}{},
+		"/api/graphql": map[string]interface{}{"data": map[string]interface{}{"repository": map[string]interface{}{"pullRequest": map[string]interface{}{
+			"number": 1, "author": map[string]string{"__typename": "Bot", "login": "dependabot"}, "createdAt": created, "mergedAt": created.Add(time.Hour), "baseRefName": "master",
+		}}}},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	opts := Options{BaseURL: srv.URL + "/api/v3/"}
+
+	rest, err := NewClient("token", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gql, err := NewGraphQLClient("token", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, cli := range map[string]vcs.Client{"REST": rest, "GraphQL": gql} {
+		pr, err := cli.GetPRInfo(context.Background(), "owner", "repo", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pr.Creator != "dependabot[bot]" || !vcs.IsBot(vcs.DefaultBots, pr.Creator) {
+			t.Errorf("%s creator = %q, want the dependabot[bot] bot", name, pr.Creator)
+		}
+	}
+}
+
+// TestGraphQLWaitsForPrimaryRateLimit checks that a query limited by the
+// primary rate limit is run again once it resets, however many times it is
+// limited.
+func TestGraphQLWaitsForPrimaryRateLimit(t *testing.T) {
+	limited := maxAbuseRetries + 2
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= limited {
+			// already reset, so that the test doesn't wait
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []interface{}{map[string]string{"type": "RATE_LIMITED", "message": "API rate limit exceeded"}}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"repository": map[string]interface{}{"pullRequest": map[string]interface{}{"number": 1}}}})
+	}))
+	defer srv.Close()
+
+	cli, err := NewGraphQLClient("token", Options{BaseURL: srv.URL + "/api/v3/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.GetPRInfo(context.Background(), "owner", "repo", 1); err != nil {
+		t.Fatal(err)
+	}
+	if requests != limited+1 {
+		t.Errorf("sent %d requests, want %d", requests, limited+1)
+	}
+}
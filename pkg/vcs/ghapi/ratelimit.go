@@ -0,0 +1,126 @@
+package ghapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	// callsPerPR is the number of REST calls GetPRInfo makes for a PR with
+	// up to 50 commits and 100 reviews, events, inline and conversation
+	// comments.
+	callsPerPR = 7
+	// maxAbuseRetries bounds the retries after hitting a secondary rate limit.
+	maxAbuseRetries = 5
+	// abuseBackoff is the first wait after a secondary rate limit without a
+	// Retry-After header; it doubles on every retry.
+	abuseBackoff = time.Minute
+)
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// call runs an API request. When GitHub rate limits it, call waits until the
+// primary limit resets, or backs off after a secondary (abuse) limit, and
+// runs it again.
+func (cli *Client) call(ctx context.Context, request func() (*github.Response, error)) error {
+	for retries := 0; ; retries++ {
+		resp, err := request()
+		if resp != nil {
+			cli.rateMu.Lock()
+			cli.rate = resp.Rate
+			cli.rateMu.Unlock()
+		}
+
+		var rateErr *github.RateLimitError
+		var abuseErr *github.AbuseRateLimitError
+		var wait time.Duration
+		switch {
+		case errors.As(err, &rateErr):
+			wait = time.Until(rateErr.Rate.Reset.Time) + time.Second
+			log.Printf("Rate limit of %d requests exhausted, waiting %s until it resets", rateErr.Rate.Limit, wait.Round(time.Second))
+		case errors.As(err, &abuseErr) && retries < maxAbuseRetries:
+			wait = abuseBackoff << retries
+			if abuseErr.RetryAfter != nil {
+				wait = *abuseErr.RetryAfter
+			}
+			log.Printf("Secondary rate limit hit, retrying in %s", wait.Round(time.Second))
+		default:
+			return err
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func logRate(rate github.Rate) {
+	log.Printf("Rate limit: %d of %d requests left, resets at %s", rate.Remaining, rate.Limit, rate.Reset.Format("15:04:05"))
+}
+
+// rateLimitWait tells how long to wait after a rate limited response, from
+// its headers or by backing off.
+func rateLimitWait(header http.Header, retries int) time.Duration {
+	if secs, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)) + time.Second
+		}
+	}
+	return abuseBackoff << retries
+}
+
+// logRate logs the rate limit reported by the last response.
+func (cli *Client) logRate() {
+	cli.rateMu.Lock()
+	defer cli.rateMu.Unlock()
+	if cli.rate.Limit > 0 {
+		logRate(cli.rate)
+	}
+}
+
+// checkListBudget fails when no request is left to list the PRs. Getting
+// the rate limit doesn't count against it.
+func (cli *Client) checkListBudget(ctx context.Context) error {
+	limits, _, err := cli.c.RateLimits(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get rate limit: %w", err)
+	}
+	core := limits.GetCore()
+	logRate(*core)
+	if core.Remaining == 0 {
+		return fmt.Errorf("no requests are left to list the PRs until %s", core.Reset.Format("15:04:05"))
+	}
+	return nil
+}
+
+// checkBudget fails when fetching prCount PRs would need more requests than
+// rate has left. Responses without rate limit headers are not checked.
+func checkBudget(prCount int, rate github.Rate) error {
+	if rate.Limit == 0 {
+		return nil
+	}
+	if estimated := prCount * callsPerPR; estimated > rate.Remaining {
+		return fmt.Errorf("fetching %d PRs needs up to %d requests but only %d are left until %s",
+			prCount, estimated, rate.Remaining, rate.Reset.Format("15:04:05"))
+	}
+	return nil
+}
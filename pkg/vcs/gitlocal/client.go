@@ -0,0 +1,292 @@
+package gitlocal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+var (
+	mergeSubject  = regexp.MustCompile(`^Merge pull request #(\d+) from ([^/\s]+)`)
+	squashSubject = regexp.MustCompile(`\(#(\d+)\)$`)
+)
+
+// Client reads merged PRs from the history of a local clone, without any
+// network access. PRs are found in the first parent history of the base
+// branch, either as "Merge pull request #N from ..." merge commits or as
+// squashed/rebased commits whose subject ends in "(#N)".
+//
+// The git history has no notion of reviews, so review related fields are
+// left empty, and the PR creation is approximated by its first commit.
+// Squashed PRs have a single commit dated when they were merged, so their
+// creation and commit times are unknown and they are left out of the lead
+// time KPIs. The owner and repo arguments of its methods are ignored.
+type Client struct {
+	dir string
+}
+
+// mergeCommit is a commit on the base branch that merged a PR.
+type mergeCommit struct {
+	sha      string
+	parents  []string
+	mergedAt time.Time
+	number   int
+	creator  string
+}
+
+func NewClient(dir string) *Client {
+	return &Client{
+		dir: dir,
+	}
+}
+
+func (cli *Client) git(ctx context.Context, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", cli.dir}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// resolveBranch returns the ref of base, falling back to its origin
+// remote-tracking branch for clones without a local branch.
+func (cli *Client) resolveBranch(ctx context.Context, base string) (string, error) {
+	var err error
+	for _, ref := range []string{base, "origin/" + base} {
+		if _, err = cli.git(ctx, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err == nil {
+			return ref, nil
+		}
+	}
+	return "", err
+}
+
+// listBranches returns the names of the branches matching bases, local or
+// from the origin remote.
+func (cli *Client) listBranches(ctx context.Context, bases []string) ([]string, error) {
+	out, err := cli.git(ctx, "for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes/origin")
+	if err != nil {
+		return nil, err
+	}
+	var branches []string
+	seen := map[string]bool{}
+	for _, ref := range strings.Fields(out) {
+		name := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/remotes/origin/")
+		if name == "HEAD" || seen[name] || !vcs.MatchBase(bases, name) {
+			continue
+		}
+		seen[name] = true
+		branches = append(branches, name)
+	}
+	return branches, nil
+}
+
+// getMergeCommits lists the PR merges on the first parent history of ref,
+// optionally limited to commits made after since.
+func (cli *Client) getMergeCommits(ctx context.Context, ref string, since time.Time) ([]mergeCommit, error) {
+	args := []string{"log", "--first-parent", "--format=%H%x1f%P%x1f%cI%x1f%an%x1f%s"}
+	if !since.IsZero() {
+		args = append(args, "--since="+since.Format(time.RFC3339))
+	}
+	out, err := cli.git(ctx, append(args, ref)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var merges []mergeCommit
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		mc := mergeCommit{sha: fields[0], parents: strings.Fields(fields[1])}
+		if mc.mergedAt, err = time.Parse(time.RFC3339, fields[2]); err != nil {
+			return nil, fmt.Errorf("failed to parse date of commit %s: %w", mc.sha, err)
+		}
+		if m := mergeSubject.FindStringSubmatch(fields[4]); m != nil && len(mc.parents) > 1 {
+			mc.number, _ = strconv.Atoi(m[1])
+			mc.creator = m[2]
+		} else if m := squashSubject.FindStringSubmatch(fields[4]); m != nil {
+			mc.number, _ = strconv.Atoi(m[1])
+			mc.creator = fields[3]
+		} else {
+			continue
+		}
+		merges = append(merges, mc)
+	}
+	return merges, nil
+}
+
+func (cli *Client) getCommitTimes(ctx context.Context, mc mergeCommit) (first time.Time, last time.Time, count int, err error) {
+	log.Printf("Getting first and last commit from %d", mc.number)
+	if len(mc.parents) < 2 {
+		count, err = cli.getSquashedCommits(ctx, mc)
+		return
+	}
+	out, err := cli.git(ctx, "log", "--format=%cI", mc.parents[0]+".."+mc.parents[1])
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Fields(out) {
+		t, err := time.Parse(time.RFC3339, l)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("failed to parse commit date: %w", err)
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+		count++
+	}
+	return
+}
+
+// getSquashedCommits counts the commits of a squashed PR from the "* "
+// lines GitHub lists them with in the message body. Those lines carry no
+// dates, and the squash commit is dated when the PR was merged, so the
+// commit times are left unknown.
+func (cli *Client) getSquashedCommits(ctx context.Context, mc mergeCommit) (int, error) {
+	out, err := cli.git(ctx, "log", "-1", "--format=%b", mc.sha)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "* ") {
+			count++
+		}
+	}
+	if count == 0 {
+		count = 1
+	}
+	return count, nil
+}
+
+func (cli *Client) getChanges(ctx context.Context, mc mergeCommit) (files int, lines int, err error) {
+	var out string
+	if len(mc.parents) > 1 {
+		out, err = cli.git(ctx, "diff", "--numstat", mc.parents[0]+"..."+mc.parents[1])
+	} else {
+		out, err = cli.git(ctx, "show", "--numstat", "--format=", mc.sha)
+	}
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 3 {
+			continue
+		}
+		files++
+		// binary files are reported as "-"
+		added, _ := strconv.Atoi(fields[0])
+		deleted, _ := strconv.Atoi(fields[1])
+		lines += added + deleted
+	}
+	return
+}
+
+func (cli *Client) toPR(ctx context.Context, mc mergeCommit, base string) (vcs.PR, error) {
+	log.Printf("Fetching info for PR %d", mc.number)
+	fc, lc, commits, err := cli.getCommitTimes(ctx, mc)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	files, lines, err := cli.getChanges(ctx, mc)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	head := mc.sha
+	if len(mc.parents) > 1 {
+		head = mc.parents[1]
+	}
+	return vcs.PR{
+		Number:          mc.number,
+		Creator:         mc.creator,
+		CreatedAt:       fc,
+		MergedAt:        mc.mergedAt,
+		ChangedFiles:    files,
+		ChangedLines:    lines,
+		Base:            base,
+		Head:            head,
+		Commits:         commits,
+		FirstCommitAt:   fc,
+		LastCommitAt:    lc,
+		CommentsUnknown: true,
+	}, nil
+}
+
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	branches := bases
+	if _, single := vcs.SingleBase(bases); !single {
+		var err error
+		if branches, err = cli.listBranches(ctx, bases); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Reading merged PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	var pRList []vcs.PR
+	seen := map[int]bool{}
+	for _, base := range branches {
+		ref, err := cli.resolveBranch(ctx, base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		merges, err := cli.getMergeCommits(ctx, ref, from)
+		if err != nil {
+			return nil, err
+		}
+		for _, mc := range merges {
+			if mc.mergedAt.Before(from) || mc.mergedAt.After(to) {
+				log.Printf("Discarded PR: %d out of the date range", mc.number)
+				continue
+			}
+			// a PR shows up on every branch its base was later merged into
+			if seen[mc.number] {
+				continue
+			}
+			seen[mc.number] = true
+			pr, err := cli.toPR(ctx, mc, base)
+			if err != nil {
+				if ctx.Err() != nil {
+					return pRList, ctx.Err()
+				}
+				return nil, err
+			}
+			pRList = append(pRList, pr)
+		}
+	}
+	return pRList, nil
+}
+
+// GetPRInfo looks for prNum on the first parent history of the checked out
+// branch.
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	base, err := cli.git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	merges, err := cli.getMergeCommits(ctx, "HEAD", time.Time{})
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	for _, mc := range merges {
+		if mc.number == prNum {
+			return cli.toPR(ctx, mc, strings.TrimSpace(base))
+		}
+	}
+	return vcs.PR{}, fmt.Errorf("PR %d not found in the history of %s", prNum, strings.TrimSpace(base))
+}
@@ -0,0 +1,105 @@
+package gitlocal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// newRepo returns a clone with a merged PR, a squashed PR and a plain commit
+// on its master branch.
+func newRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "gitlocal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	date := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	git := func(args ...string) {
+		date = date.Add(time.Hour)
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=ann", "GIT_AUTHOR_EMAIL=ann@example.com",
+			"GIT_COMMITTER_NAME=ann", "GIT_COMMITTER_EMAIL=ann@example.com",
+			"GIT_AUTHOR_DATE="+date.Format(time.RFC3339), "GIT_COMMITTER_DATE="+date.Format(time.RFC3339),
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", name)
+	}
+
+	git("init", "-q")
+	git("checkout", "-q", "-b", "master")
+	write("README", "hello\n")
+	git("commit", "-q", "-m", "Initial commit")
+
+	git("checkout", "-q", "-b", "feature")
+	write("a.go", "package a\n")
+	git("commit", "-q", "-m", "Add a")
+	write("a.go", "package a\n\nconst A = 1\n")
+	git("commit", "-q", "-m", "Set A")
+	git("checkout", "-q", "master")
+	git("merge", "-q", "--no-ff", "-m", "Merge pull request #1 from bob/feature", "feature")
+
+	write("b.go", "package b\n")
+	git("commit", "-q", "-m", "Add b (#2)", "-m", "* Add b\n* Fix b")
+	write("README", "hello world\n")
+	git("commit", "-q", "-m", "Update the readme")
+	return dir
+}
+
+func TestGetMergedPRList(t *testing.T) {
+	cli := NewClient(newRepo(t))
+	from := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	prs, err := cli.GetMergedPRList(context.Background(), "", "", from, from.AddDate(0, 0, 1), []string{"master"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 2 {
+		t.Fatalf("listed %+v, want PRs 2 and 1", prs)
+	}
+
+	squashed, merged := prs[0], prs[1]
+	if squashed.Number != 2 || squashed.Creator != "ann" || squashed.Commits != 2 || squashed.ChangedFiles != 1 || squashed.ChangedLines != 1 {
+		t.Errorf("squashed PR = %+v, want #2 by ann with 2 commits changing 1 line in 1 file", squashed)
+	}
+	if !squashed.CreatedAt.IsZero() || squashed.PRLeadTime(vcs.KPIOptions{}) != 0 {
+		t.Errorf("squashed PR created at %s, want its creation unknown", squashed.CreatedAt)
+	}
+	if merged.Number != 1 || merged.Creator != "bob" || merged.Commits != 2 || merged.ChangedFiles != 1 || merged.ChangedLines != 3 || merged.Base != "master" {
+		t.Errorf("merged PR = %+v, want #1 by bob with 2 commits changing 3 lines in 1 file", merged)
+	}
+	if !merged.CreatedAt.Equal(merged.FirstCommitAt) || !merged.LastCommitAt.After(merged.FirstCommitAt) || !merged.MergedAt.After(merged.LastCommitAt) {
+		t.Errorf("merged PR committed from %s to %s and merged at %s", merged.FirstCommitAt, merged.LastCommitAt, merged.MergedAt)
+	}
+}
+
+func TestGetPRInfo(t *testing.T) {
+	cli := NewClient(newRepo(t))
+	pr, err := cli.GetPRInfo(context.Background(), "", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Number != 1 || pr.Base != "master" {
+		t.Errorf("got PR %d on %s, want 1 on master", pr.Number, pr.Base)
+	}
+	if _, err := cli.GetPRInfo(context.Background(), "", "", 3); err == nil {
+		t.Error("found PR 3, which was never merged")
+	}
+}
@@ -0,0 +1,287 @@
+package glapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+const approvedNote = "approved this merge request"
+
+type Client struct {
+	c       *http.Client
+	baseURL string
+	token   string
+}
+
+type user struct {
+	Username string `json:"username"`
+}
+
+type mergeRequest struct {
+	IID          int        `json:"iid"`
+	Author       user       `json:"author"`
+	CreatedAt    time.Time  `json:"created_at"`
+	MergedAt     *time.Time `json:"merged_at"`
+	TargetBranch string     `json:"target_branch"`
+	SHA          string     `json:"sha"`
+	ChangesCount string     `json:"changes_count"`
+	Labels       []string   `json:"labels"`
+	Milestone    *milestone `json:"milestone"`
+}
+
+type milestone struct {
+	Title string `json:"title"`
+}
+
+type commit struct {
+	CommittedDate time.Time `json:"committed_date"`
+}
+
+type note struct {
+	Type      string    `json:"type"`
+	Body      string    `json:"body"`
+	System    bool      `json:"system"`
+	Author    user      `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type diff struct {
+	Diff string `json:"diff"`
+}
+
+// NewClient returns a client for the GitLab instance at baseURL
+// (e.g. https://gitlab.com) authenticated with a personal access token.
+func NewClient(baseURL, accessToken string) *Client {
+	return &Client{
+		c:       http.DefaultClient,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   accessToken,
+	}
+}
+
+func projectPath(owner, repo string) string {
+	return "/projects/" + url.PathEscape(owner+"/"+repo)
+}
+
+// get fetches a single page of the GitLab API into v and returns the number of
+// the next page, or an empty string if there is none.
+func (cli *Client) get(ctx context.Context, path string, query url.Values, v interface{}) (string, error) {
+	u := cli.baseURL + "/api/v4" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("PRIVATE-TOKEN", cli.token)
+
+	resp, err := cli.c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("GET %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("failed to decode response of %s: %w", u, err)
+	}
+	return resp.Header.Get("X-Next-Page"), nil
+}
+
+func (cli *Client) getFirstAndLastCommitTime(ctx context.Context, owner, repo string, iid int) (first time.Time, last time.Time, count int, err error) {
+	log.Printf("Getting first and last commit from %d", iid)
+	query := url.Values{"per_page": {"100"}}
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		var commits []commit
+		page, err = cli.get(ctx, fmt.Sprintf("%s/merge_requests/%d/commits", projectPath(owner, repo), iid), query, &commits)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		for _, c := range commits {
+			if first.IsZero() || c.CommittedDate.Before(first) {
+				first = c.CommittedDate
+			}
+			if c.CommittedDate.After(last) {
+				last = c.CommittedDate
+			}
+		}
+		count += len(commits)
+	}
+	return
+}
+
+// getNotes returns the human notes and the approval system notes, in
+// creation order.
+func (cli *Client) getNotes(ctx context.Context, owner, repo string, iid int) ([]note, error) {
+	log.Printf("Getting notes from %d", iid)
+	var kept []note
+	query := url.Values{"per_page": {"100"}, "sort": {"asc"}, "order_by": {"created_at"}}
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		var notes []note
+		var err error
+		page, err = cli.get(ctx, fmt.Sprintf("%s/merge_requests/%d/notes", projectPath(owner, repo), iid), query, &notes)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range notes {
+			if n.System && n.Body != approvedNote {
+				continue
+			}
+			kept = append(kept, n)
+		}
+	}
+	return kept, nil
+}
+
+func (cli *Client) getChangedLines(ctx context.Context, owner, repo string, iid int) (int, error) {
+	lines := 0
+	query := url.Values{"per_page": {"100"}}
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		var diffs []diff
+		var err error
+		page, err = cli.get(ctx, fmt.Sprintf("%s/merge_requests/%d/diffs", projectPath(owner, repo), iid), query, &diffs)
+		if err != nil {
+			return 0, err
+		}
+		for _, d := range diffs {
+			for _, l := range strings.Split(d.Diff, "\n") {
+				if strings.HasPrefix(l, "+") || strings.HasPrefix(l, "-") {
+					lines++
+				}
+			}
+		}
+	}
+	return lines, nil
+}
+
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	query := url.Values{
+		"state":         {"merged"},
+		"updated_after": {from.Format(time.RFC3339)},
+		"order_by":      {"updated_at"},
+		"per_page":      {"100"},
+	}
+	if base, single := vcs.SingleBase(bases); single {
+		if _, err := cli.get(ctx, fmt.Sprintf("%s/repository/branches/%s", projectPath(owner, repo), url.PathEscape(base)), nil, &struct{}{}); err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		query.Set("target_branch", base)
+	}
+
+	var mRNums []int
+	log.Printf("Fetching Merged MR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		var mrs []mergeRequest
+		var err error
+		page, err = cli.get(ctx, projectPath(owner, repo)+"/merge_requests", query, &mrs)
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			if mr.MergedAt == nil || mr.MergedAt.Before(from) || mr.MergedAt.After(to) {
+				log.Printf("Discarded MR: %d out of the date range", mr.IID)
+				continue
+			}
+			if !vcs.MatchBase(bases, mr.TargetBranch) {
+				continue
+			}
+			mRNums = append(mRNums, mr.IID)
+		}
+	}
+	return vcs.FetchPRs(ctx, mRNums, 1, func(ctx context.Context, mrNum int) (vcs.PR, error) {
+		return cli.GetPRInfo(ctx, owner, repo, mrNum)
+	})
+}
+
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for MR %d", prNum)
+	var mr mergeRequest
+	if _, err := cli.get(ctx, fmt.Sprintf("%s/merge_requests/%d", projectPath(owner, repo), prNum), nil, &mr); err != nil {
+		return vcs.PR{}, err
+	}
+
+	fc, lc, commits, err := cli.getFirstAndLastCommitTime(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	changedLines, err := cli.getChangedLines(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	notes, err := cli.getNotes(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+
+	// every human note is a comment, as on GitHub, but only the notes of
+	// others than the author review the MR
+	reviewComments := 0
+	reviews := []vcs.Review{}
+	comments := []vcs.Comment{}
+	for _, n := range notes {
+		if n.System {
+			reviews = append(reviews, vcs.Review{Author: n.Author.Username, State: vcs.ReviewApproved, SubmittedAt: n.CreatedAt})
+			continue
+		}
+		kind := vcs.CommentConversation
+		if n.Type == "DiffNote" {
+			kind = vcs.CommentReview
+			reviewComments++
+		}
+		comments = append(comments, vcs.Comment{Author: n.Author.Username, Kind: kind, CreatedAt: n.CreatedAt})
+		if n.Author.Username != mr.Author.Username {
+			reviews = append(reviews, vcs.Review{Author: n.Author.Username, State: vcs.ReviewCommented, SubmittedAt: n.CreatedAt, BodyLength: len(n.Body)})
+		}
+	}
+	fr, lr := vcs.ReviewSpan(reviews)
+
+	// changes_count is a string that is capped at "1000+" for big MRs
+	changedFiles, _ := strconv.Atoi(strings.TrimSuffix(mr.ChangesCount, "+"))
+
+	var mergedAt time.Time
+	if mr.MergedAt != nil {
+		mergedAt = *mr.MergedAt
+	}
+	milestone := ""
+	if mr.Milestone != nil {
+		milestone = mr.Milestone.Title
+	}
+	return vcs.PR{
+		Number:         mr.IID,
+		Creator:        mr.Author.Username,
+		CreatedAt:      mr.CreatedAt,
+		MergedAt:       mergedAt,
+		ChangedFiles:   changedFiles,
+		ChangedLines:   changedLines,
+		ReviewComments: reviewComments,
+		Base:           mr.TargetBranch,
+		Head:           mr.SHA,
+		Commits:        commits,
+		FirstCommitAt:  fc,
+		LastCommitAt:   lc,
+		FirstCommentAt: fr,
+		LastCommentAt:  lr,
+		Reviews:        reviews,
+		Comments:       comments,
+		Labels:         mr.Labels,
+		Milestone:      milestone,
+	}, nil
+}
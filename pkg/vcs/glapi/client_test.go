@@ -0,0 +1,61 @@
+package glapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// TestGetPRInfoNotes checks that the notes of the MR author are counted as
+// comments, like on GitHub, but not as reviews.
+func TestGetPRInfoNotes(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2020, 8, 3, h, 0, 0, 0, time.UTC) }
+	author := map[string]string{"username": "author"}
+	reviewer := map[string]string{"username": "reviewer"}
+	mr := "/api/v4/projects/owner/repo/merge_requests/1"
+	responses := map[string]interface{}{
+		mr:              map[string]interface{}{"iid": 1, "author": author, "created_at": at(1), "merged_at": at(9), "target_branch": "master", "changes_count": "1"},
+		mr + "/commits": []interface{}{map[string]interface{}{"committed_date": at(0)}},
+		mr + "/diffs":   []interface{}{map[string]string{"diff": "+a\n-b"}},
+		mr + "/notes": []interface{}{
+			map[string]interface{}{"type": "DiffNote", "body": "nit", "author": reviewer, "created_at": at(2)},
+			map[string]interface{}{"type": "DiffNote", "body": "done", "author": author, "created_at": at(3)},
+			map[string]interface{}{"body": "thanks", "author": author, "created_at": at(4)},
+			map[string]interface{}{"body": "changed the description", "system": true, "author": author, "created_at": at(5)},
+			map[string]interface{}{"body": approvedNote, "system": true, "author": reviewer, "created_at": at(6)},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL.RequestURI())
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer srv.Close()
+
+	pr, err := NewClient(srv.URL, "token").GetPRInfo(context.Background(), "owner", "repo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := vcs.KPIOptions{}
+	if got := pr.HumanReviewComments(opts); got != 2 {
+		t.Errorf("review comments = %d, want 2", got)
+	}
+	if got := pr.ConversationComments(opts); got != 1 {
+		t.Errorf("conversation comments = %d, want 1", got)
+	}
+	if len(pr.Reviews) != 2 || pr.Reviews[0].State != vcs.ReviewCommented || pr.Reviews[1].State != vcs.ReviewApproved {
+		t.Errorf("reviews = %+v, want the reviewer comment and approval", pr.Reviews)
+	}
+	if !pr.FirstCommentAt.Equal(at(2)) || !pr.LastCommentAt.Equal(at(6)) {
+		t.Errorf("reviewed from %s to %s, want %s to %s", pr.FirstCommentAt, pr.LastCommentAt, at(2), at(6))
+	}
+}
@@ -0,0 +1,308 @@
+package grapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+const (
+	pageSize = 100
+	// timeLayout is the UTC timestamp format used by the Gerrit REST API.
+	timeLayout = "2006-01-02 15:04:05.000000000"
+	// magicPrefix guards every Gerrit JSON response against XSSI.
+	magicPrefix = ")]}'"
+)
+
+// Client reads merged changes from the Gerrit REST API and maps them onto
+// PRs: patch sets count as commits and reviewer votes and messages as
+// reviews. A repository is the Gerrit project owner/repo, or just repo when
+// the owner is empty.
+type Client struct {
+	c        *http.Client
+	baseURL  string
+	username string
+	password string
+}
+
+// timestamp decodes Gerrit timestamps, which are not RFC 3339.
+type timestamp struct {
+	time.Time
+}
+
+func (t *timestamp) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(timeLayout, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+type account struct {
+	AccountID int    `json:"_account_id"`
+	Username  string `json:"username"`
+}
+
+type change struct {
+	Number            int       `json:"_number"`
+	Owner             account   `json:"owner"`
+	Branch            string    `json:"branch"`
+	Created           timestamp `json:"created"`
+	Submitted         timestamp `json:"submitted"`
+	Insertions        int       `json:"insertions"`
+	Deletions         int       `json:"deletions"`
+	TotalCommentCount int       `json:"total_comment_count"`
+	CurrentRevision   string    `json:"current_revision"`
+	MoreChanges       bool      `json:"_more_changes"`
+	Hashtags          []string  `json:"hashtags"`
+	Revisions         map[string]struct {
+		Number  int                        `json:"_number"`
+		Created timestamp                  `json:"created"`
+		Files   map[string]json.RawMessage `json:"files"`
+	} `json:"revisions"`
+	Messages []struct {
+		Author  account   `json:"author"`
+		Date    timestamp `json:"date"`
+		Tag     string    `json:"tag"`
+		Message string    `json:"message"`
+	} `json:"messages"`
+	Labels map[string]struct {
+		All []struct {
+			account
+			Value int        `json:"value"`
+			Date  *timestamp `json:"date"`
+		} `json:"all"`
+		// Values describes every vote of the label, keyed by " 0", "+1"...
+		Values map[string]string `json:"values"`
+	} `json:"labels"`
+}
+
+// changeOptions are the additional fields requested for every change.
+var changeOptions = []string{"ALL_REVISIONS", "CURRENT_FILES", "MESSAGES", "DETAILED_LABELS", "DETAILED_ACCOUNTS"}
+
+// NewClient returns a client for the Gerrit server at baseURL. With
+// credentials, requests are authenticated with the HTTP password of the
+// user; otherwise only anonymous access is used.
+func NewClient(baseURL, username, password string) *Client {
+	return &Client{
+		c:        http.DefaultClient,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+	}
+}
+
+func projectName(owner, repo string) string {
+	return path.Join(owner, repo)
+}
+
+func (cli *Client) get(ctx context.Context, endpoint string, query url.Values, v interface{}) error {
+	u := cli.baseURL
+	if cli.username != "" {
+		u += "/a" // authenticated endpoints are prefixed with /a/
+	}
+	u += endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if cli.username != "" {
+		req.SetBasicAuth(cli.username, cli.password)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cli.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	body = bytes.TrimPrefix(body, []byte(magicPrefix))
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", u, err)
+	}
+	return nil
+}
+
+// getFirstAndLastCommitTime uses the patch sets of a change as its commits.
+func getFirstAndLastCommitTime(c change) (first time.Time, last time.Time) {
+	for _, r := range c.Revisions {
+		if first.IsZero() || r.Created.Before(first) {
+			first = r.Created.Time
+		}
+		if r.Created.After(last) {
+			last = r.Created.Time
+		}
+	}
+	return
+}
+
+// defaultMaxVote is the approving vote of the default Code-Review label.
+const defaultMaxVote = 2
+
+// maxVote returns the highest vote of a label from its described values.
+func maxVote(values map[string]string) int {
+	max, found := 0, false
+	for v := range values {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		if !found || n > max {
+			max, found = n, true
+		}
+	}
+	if !found {
+		return defaultMaxVote
+	}
+	return max
+}
+
+// voteKey identifies a vote by its account and date, which are shared by the
+// message Gerrit posts along with it.
+type voteKey struct {
+	accountID int
+	date      time.Time
+}
+
+// getReviews collects the Code-Review votes and the human messages of
+// everyone but the change owner, in submission order. Only the highest vote
+// of the label approves, lower positive votes are comments and negative
+// ones change requests. The message posted along with a vote is part of it
+// instead of another review.
+func getReviews(c change) []vcs.Review {
+	var reviews []vcs.Review
+	label := c.Labels["Code-Review"]
+	approval := maxVote(label.Values)
+	votes := map[voteKey]int{}
+	for _, v := range label.All {
+		if v.AccountID == c.Owner.AccountID || v.Value == 0 || v.Date == nil {
+			continue
+		}
+		state := vcs.ReviewCommented
+		if v.Value >= approval {
+			state = vcs.ReviewApproved
+		} else if v.Value < 0 {
+			state = vcs.ReviewChangesRequested
+		}
+		votes[voteKey{v.AccountID, v.Date.Time}] = len(reviews)
+		reviews = append(reviews, vcs.Review{Author: v.Username, State: state, SubmittedAt: v.Date.Time})
+	}
+	for _, m := range c.Messages {
+		// tagged messages are generated by Gerrit or by bots (autogenerated:*)
+		if m.Author.AccountID == c.Owner.AccountID || m.Author.AccountID == 0 || m.Tag != "" {
+			continue
+		}
+		if i, ok := votes[voteKey{m.Author.AccountID, m.Date.Time}]; ok {
+			reviews[i].BodyLength = len(m.Message)
+			continue
+		}
+		reviews = append(reviews, vcs.Review{Author: m.Author.Username, State: vcs.ReviewCommented, SubmittedAt: m.Date.Time, BodyLength: len(m.Message)})
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].SubmittedAt.Before(reviews[j].SubmittedAt) })
+	return reviews
+}
+
+func toPR(c change) vcs.PR {
+	fc, lc := getFirstAndLastCommitTime(c)
+	reviews := getReviews(c)
+	fr, lr := vcs.ReviewSpan(reviews)
+	return vcs.PR{
+		Number:          c.Number,
+		Creator:         c.Owner.Username,
+		CreatedAt:       c.Created.Time,
+		MergedAt:        c.Submitted.Time,
+		ChangedFiles:    len(c.Revisions[c.CurrentRevision].Files),
+		ChangedLines:    c.Insertions + c.Deletions,
+		ReviewComments:  c.TotalCommentCount,
+		Base:            c.Branch,
+		Head:            c.CurrentRevision,
+		Commits:         len(c.Revisions),
+		FirstCommitAt:   fc,
+		LastCommitAt:    lc,
+		FirstCommentAt:  fr,
+		LastCommentAt:   lr,
+		Reviews:         reviews,
+		CommentsUnknown: true,
+		Labels:          c.Hashtags,
+	}
+}
+
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	project := projectName(owner, repo)
+	q := fmt.Sprintf(`project:"%s" status:merged mergedafter:"%s" mergedbefore:"%s"`,
+		project, from.UTC().Format(timeLayout), to.UTC().Format(timeLayout))
+	if base, single := vcs.SingleBase(bases); single {
+		if err := cli.get(ctx, fmt.Sprintf("/projects/%s/branches/%s", url.PathEscape(project), url.PathEscape(base)), nil, &struct{}{}); err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+		q += fmt.Sprintf(` branch:"%s"`, base)
+	}
+
+	var pRList []vcs.PR
+	query := url.Values{
+		"q": {q},
+		"o": changeOptions,
+		"n": {fmt.Sprint(pageSize)},
+	}
+	log.Printf("Fetching Merged Change List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	for start := 0; ; start += pageSize {
+		query.Set("S", fmt.Sprint(start))
+		var changes []change
+		if err := cli.get(ctx, "/changes/", query, &changes); err != nil {
+			if ctx.Err() != nil {
+				return pRList, ctx.Err()
+			}
+			return nil, err
+		}
+		for _, c := range changes {
+			if !vcs.MatchBase(bases, c.Branch) {
+				continue
+			}
+			log.Printf("Fetching info for change %d", c.Number)
+			pRList = append(pRList, toPR(c))
+		}
+		if len(changes) == 0 || !changes[len(changes)-1].MoreChanges {
+			break
+		}
+	}
+	return pRList, nil
+}
+
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for change %d", prNum)
+	var c change
+	id := url.PathEscape(fmt.Sprintf("%s~%d", projectName(owner, repo), prNum))
+	if err := cli.get(ctx, "/changes/"+id, url.Values{"o": changeOptions}, &c); err != nil {
+		return vcs.PR{}, err
+	}
+	return toPR(c), nil
+}
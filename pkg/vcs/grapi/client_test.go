@@ -0,0 +1,119 @@
+package grapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+func hour(h int) time.Time {
+	return time.Date(2020, 8, 1, h, 0, 0, 0, time.UTC)
+}
+
+func at(h int) string {
+	return hour(h).Format(timeLayout)
+}
+
+// serveChange answers the request of a change with c, behind the XSSI
+// prefix of Gerrit.
+func serveChange(t *testing.T, path string, c interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("unexpected request %s", r.URL.RequestURI())
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(magicPrefix + "\n"))
+		json.NewEncoder(w).Encode(c)
+	}))
+}
+
+func TestGetPRInfoReviews(t *testing.T) {
+	owner := map[string]interface{}{"_account_id": 1, "username": "ann"}
+	bob := map[string]interface{}{"_account_id": 2, "username": "bob"}
+	eve := map[string]interface{}{"_account_id": 3, "username": "eve"}
+	joe := map[string]interface{}{"_account_id": 4, "username": "joe"}
+	vote := func(who map[string]interface{}, value, h int) map[string]interface{} {
+		v := map[string]interface{}{"value": value, "date": at(h)}
+		for k, x := range who {
+			v[k] = x
+		}
+		return v
+	}
+	message := func(who map[string]interface{}, h int, tag, text string) map[string]interface{} {
+		return map[string]interface{}{"author": who, "date": at(h), "tag": tag, "message": text}
+	}
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   []vcs.Review
+	}{
+		{
+			name:   "default label",
+			values: nil,
+			want: []vcs.Review{
+				{Author: "eve", State: vcs.ReviewChangesRequested, SubmittedAt: hour(2), BodyLength: len("Patch Set 1: Code-Review-1")},
+				{Author: "joe", State: vcs.ReviewCommented, SubmittedAt: hour(3), BodyLength: len("Patch Set 1: Code-Review+1\n\nLooks ok")},
+				{Author: "eve", State: vcs.ReviewCommented, SubmittedAt: hour(4), BodyLength: len("why?")},
+				{Author: "bob", State: vcs.ReviewApproved, SubmittedAt: hour(5), BodyLength: len("Patch Set 1: Code-Review+2")},
+			},
+		},
+		{
+			name:   "label up to +1",
+			values: map[string]string{"-1": "No", " 0": "No score", "+1": "Looks good"},
+			want: []vcs.Review{
+				{Author: "eve", State: vcs.ReviewChangesRequested, SubmittedAt: hour(2), BodyLength: len("Patch Set 1: Code-Review-1")},
+				{Author: "joe", State: vcs.ReviewApproved, SubmittedAt: hour(3), BodyLength: len("Patch Set 1: Code-Review+1\n\nLooks ok")},
+				{Author: "eve", State: vcs.ReviewCommented, SubmittedAt: hour(4), BodyLength: len("why?")},
+				{Author: "bob", State: vcs.ReviewApproved, SubmittedAt: hour(5), BodyLength: len("Patch Set 1: Code-Review+2")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := map[string]interface{}{
+				"_number":          7,
+				"owner":            owner,
+				"branch":           "master",
+				"created":          at(0),
+				"submitted":        at(6),
+				"current_revision": "abc",
+				"revisions":        map[string]interface{}{"abc": map[string]interface{}{"_number": 1, "created": at(1)}},
+				"labels": map[string]interface{}{"Code-Review": map[string]interface{}{
+					"values": tt.values,
+					"all": []interface{}{
+						vote(owner, 2, 1),
+						vote(eve, -1, 2),
+						vote(joe, 1, 3),
+						vote(bob, 2, 5),
+					},
+				}},
+				"messages": []interface{}{
+					message(owner, 1, "", "Uploaded patch set 1."),
+					message(eve, 2, "", "Patch Set 1: Code-Review-1"),
+					message(joe, 3, "", "Patch Set 1: Code-Review+1\n\nLooks ok"),
+					message(eve, 4, "", "why?"),
+					message(bob, 5, "", "Patch Set 1: Code-Review+2"),
+					message(bob, 6, "autogenerated:gerrit:merged", "Change has been successfully merged"),
+				},
+			}
+			srv := serveChange(t, "/changes/repo~7", c)
+			defer srv.Close()
+
+			pr, err := NewClient(srv.URL, "", "").GetPRInfo(context.Background(), "", "repo", 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pr.Reviews, tt.want) {
+				t.Errorf("Reviews = %+v, want %+v", pr.Reviews, tt.want)
+			}
+		})
+	}
+}
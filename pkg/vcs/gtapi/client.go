@@ -0,0 +1,280 @@
+package gtapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmartin82/mkpis/pkg/vcs"
+)
+
+// pageSize is the number of items requested per page. Gitea caps it with
+// its MAX_RESPONSE_ITEMS setting (50 by default), so pages may be shorter
+// and the end of a list is told by the response headers.
+const pageSize = 50
+
+type Client struct {
+	c       *http.Client
+	baseURL string
+	token   string
+}
+
+type user struct {
+	Login string `json:"login"`
+}
+
+type pullRequest struct {
+	Number         int        `json:"number"`
+	User           user       `json:"user"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	MergedAt       *time.Time `json:"merged_at"`
+	Additions      int        `json:"additions"`
+	Deletions      int        `json:"deletions"`
+	ChangedFiles   int        `json:"changed_files"`
+	ReviewComments int        `json:"review_comments"`
+	Base           struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	Head struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Milestone *struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+}
+
+type review struct {
+	User        user      `json:"user"`
+	State       string    `json:"state"`
+	Body        string    `json:"body"`
+	Dismissed   bool      `json:"dismissed"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+type commit struct {
+	Commit struct {
+		Committer struct {
+			Date time.Time `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+}
+
+// NewClient returns a client for the Gitea or Forgejo instance at baseURL
+// authenticated with an access token.
+func NewClient(baseURL, accessToken string) *Client {
+	return &Client{
+		c:       http.DefaultClient,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   accessToken,
+	}
+}
+
+func (cli *Client) repoURL(owner, repo string) string {
+	return fmt.Sprintf("%s/api/v1/repos/%s/%s", cli.baseURL, url.PathEscape(owner), url.PathEscape(repo))
+}
+
+// get fetches u into v and returns the response headers.
+func (cli *Client) get(ctx context.Context, u string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+cli.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cli.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("failed to decode response of %s: %w", u, err)
+	}
+	return resp.Header, nil
+}
+
+// hasNextPage tells whether a list goes on after a page, from its Link
+// header or, without one, from its X-Total-Count header and the number of
+// items seen so far.
+func hasNextPage(h http.Header, seen int) bool {
+	if links := h.Get("Link"); links != "" {
+		for _, link := range strings.Split(links, ",") {
+			if strings.Contains(link, `rel="next"`) {
+				return true
+			}
+		}
+		return false
+	}
+	total, err := strconv.Atoi(h.Get("X-Total-Count"))
+	return err == nil && seen < total
+}
+
+func (cli *Client) getFirstAndLastCommitTime(ctx context.Context, owner string, repo string, prNum int) (first time.Time, last time.Time, count int, err error) {
+	log.Printf("Getting first and last commit from %d", prNum)
+	for page := 1; ; page++ {
+		var commits []commit
+		var h http.Header
+		if h, err = cli.get(ctx, fmt.Sprintf("%s/pulls/%d/commits?page=%d&limit=%d", cli.repoURL(owner, repo), prNum, page, pageSize), &commits); err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		count += len(commits)
+		for _, c := range commits {
+			date := c.Commit.Committer.Date
+			if first.IsZero() || date.Before(first) {
+				first = date
+			}
+			if date.After(last) {
+				last = date
+			}
+		}
+		if !hasNextPage(h, count) {
+			return
+		}
+	}
+}
+
+// reviewStates maps the Gitea review states onto the vcs ones.
+var reviewStates = map[string]string{
+	"APPROVED":        vcs.ReviewApproved,
+	"REQUEST_CHANGES": vcs.ReviewChangesRequested,
+	"COMMENT":         vcs.ReviewCommented,
+}
+
+func (cli *Client) getReviews(ctx context.Context, owner string, repo string, prNum int) ([]vcs.Review, error) {
+	log.Printf("Getting reviews from %d", prNum)
+	var list []vcs.Review
+	seen := 0
+	for page := 1; ; page++ {
+		var reviews []review
+		h, err := cli.get(ctx, fmt.Sprintf("%s/pulls/%d/reviews?page=%d&limit=%d", cli.repoURL(owner, repo), prNum, page, pageSize), &reviews)
+		if err != nil {
+			return nil, err
+		}
+		seen += len(reviews)
+		for _, r := range reviews {
+			// pending reviews and review requests were never submitted
+			if r.State == "PENDING" || r.State == "REQUEST_REVIEW" || r.SubmittedAt.IsZero() {
+				continue
+			}
+			state, ok := reviewStates[r.State]
+			if !ok {
+				state = r.State
+			}
+			if r.Dismissed {
+				state = vcs.ReviewDismissed
+			}
+			list = append(list, vcs.Review{Author: r.User.Login, State: state, SubmittedAt: r.SubmittedAt, BodyLength: len(r.Body)})
+		}
+		if !hasNextPage(h, seen) {
+			return list, nil
+		}
+	}
+}
+
+func (cli *Client) GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]vcs.PR, error) {
+	if base, single := vcs.SingleBase(bases); single {
+		if _, err := cli.get(ctx, fmt.Sprintf("%s/branches/%s", cli.repoURL(owner, repo), url.PathEscape(base)), &struct{}{}); err != nil {
+			return nil, fmt.Errorf("failed to get branch %q: %w", base, err)
+		}
+	}
+
+	var pRNums []int
+	seen := 0
+	log.Printf("Fetching Closed PR List from: %s to: %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+pagination:
+	for page := 1; ; page++ {
+		var prs []pullRequest
+		h, err := cli.get(ctx, fmt.Sprintf("%s/pulls?state=closed&sort=recentupdate&page=%d&limit=%d", cli.repoURL(owner, repo), page, pageSize), &prs)
+		if err != nil {
+			return nil, err
+		}
+		seen += len(prs)
+		for _, pr := range prs {
+			if pr.UpdatedAt.Before(from) {
+				// merging updates a PR, so nothing older was merged in the window
+				break pagination
+			}
+			if pr.MergedAt == nil || !vcs.MatchBase(bases, pr.Base.Ref) {
+				continue
+			}
+			if pr.MergedAt.Before(from) || pr.MergedAt.After(to) {
+				log.Printf("Discarded PR: %d out of the date range", pr.Number)
+				continue
+			}
+			pRNums = append(pRNums, pr.Number)
+		}
+		if !hasNextPage(h, seen) {
+			break
+		}
+	}
+	return vcs.FetchPRs(ctx, pRNums, 1, func(ctx context.Context, prNum int) (vcs.PR, error) {
+		return cli.GetPRInfo(ctx, owner, repo, prNum)
+	})
+}
+
+func (cli *Client) GetPRInfo(ctx context.Context, owner, repo string, prNum int) (vcs.PR, error) {
+	log.Printf("Fetching info for PR %d", prNum)
+	var pr pullRequest
+	if _, err := cli.get(ctx, fmt.Sprintf("%s/pulls/%d", cli.repoURL(owner, repo), prNum), &pr); err != nil {
+		return vcs.PR{}, err
+	}
+
+	fc, lc, commits, err := cli.getFirstAndLastCommitTime(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	reviews, err := cli.getReviews(ctx, owner, repo, prNum)
+	if err != nil {
+		return vcs.PR{}, err
+	}
+	fr, lr := vcs.ReviewSpan(reviews)
+
+	var mergedAt time.Time
+	if pr.MergedAt != nil {
+		mergedAt = *pr.MergedAt
+	}
+	var labels []string
+	for _, l := range pr.Labels {
+		labels = append(labels, l.Name)
+	}
+	milestone := ""
+	if pr.Milestone != nil {
+		milestone = pr.Milestone.Title
+	}
+	return vcs.PR{
+		Number:          pr.Number,
+		Creator:         pr.User.Login,
+		CreatedAt:       pr.CreatedAt,
+		MergedAt:        mergedAt,
+		ChangedFiles:    pr.ChangedFiles,
+		ChangedLines:    pr.Additions + pr.Deletions,
+		ReviewComments:  pr.ReviewComments,
+		Base:            pr.Base.Ref,
+		Head:            pr.Head.SHA,
+		Commits:         commits,
+		FirstCommitAt:   fc,
+		LastCommitAt:    lc,
+		FirstCommentAt:  fr,
+		LastCommentAt:   lr,
+		Reviews:         reviews,
+		CommentsUnknown: true,
+		Labels:          labels,
+		Milestone:       milestone,
+	}, nil
+}
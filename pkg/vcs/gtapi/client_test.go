@@ -0,0 +1,50 @@
+package gtapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestGetReviewsShortPages checks that lists are followed past pages shorter
+// than pageSize, as served by instances with a low MAX_RESPONSE_ITEMS.
+func TestGetReviewsShortPages(t *testing.T) {
+	for _, header := range []string{"Link", "X-Total-Count"} {
+		t.Run(header, func(t *testing.T) {
+			const pages = 3
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				if header == "Link" {
+					if page < pages {
+						w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next",<%s?page=%d>; rel="last"`, r.URL.Path, page+1, r.URL.Path, pages))
+					} else {
+						w.Header().Set("Link", fmt.Sprintf(`<%s?page=1>; rel="first",<%s?page=%d>; rel="prev"`, r.URL.Path, r.URL.Path, page-1))
+					}
+				} else {
+					w.Header().Set("X-Total-Count", strconv.Itoa(pages))
+				}
+				fmt.Fprintf(w, `[{"user":{"login":"r%d"},"state":"APPROVED","submitted_at":"%s"}]`,
+					page, time.Date(2020, 8, page, 0, 0, 0, 0, time.UTC).Format(time.RFC3339))
+			}))
+			defer srv.Close()
+
+			cli := NewClient(srv.URL, "token")
+			reviews, err := cli.getReviews(context.Background(), "owner", "repo", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reviews) != pages {
+				t.Fatalf("got %d reviews, want %d", len(reviews), pages)
+			}
+			for i, r := range reviews {
+				if want := fmt.Sprintf("r%d", i+1); r.Author != want {
+					t.Errorf("review %d by %s, want %s", i, r.Author, want)
+				}
+			}
+		})
+	}
+}
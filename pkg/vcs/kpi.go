@@ -1,12 +1,24 @@
 package vcs
 
 import (
+	"sort"
 	"time"
 
 	"github.com/montanaflynn/stats"
 )
 
+// KPIOptions are the report-wide settings of how the KPIs are computed.
+type KPIOptions struct {
+	// AnchorAtReady makes the review KPIs of a draft PR start when it was
+	// marked as ready for review instead of when it was opened.
+	AnchorAtReady bool
+	// Bots are the accounts whose comments are counted as bot comments
+	// instead of human ones, DefaultBots when nil.
+	Bots []string
+}
+
 type KPICalculator struct {
+	opts              KPIOptions
 	prs               []PR
 	commits           []float64
 	changes           []float64
@@ -16,11 +28,21 @@ type KPICalculator struct {
 	timeToFirstReview []float64
 	lastReviewToMerge []float64
 	pRLeadTime        []float64
+	timeToApproval    []float64
+	approvalToMerge   []float64
+	approvals         []float64
+	changesRequested  []float64
+	requestLatency    []float64
+	timeInDraft       []float64
+	conversation      []float64
+	botComments       []float64
+	reviewerLatencies map[string][]float64
 }
 
-func NewKPICalculator(prs []PR) *KPICalculator {
+func NewKPICalculator(prs []PR, opts KPIOptions) *KPICalculator {
 	kpi := &KPICalculator{
-		prs: prs,
+		opts: opts,
+		prs:  prs,
 	}
 	kpi.calc()
 	return kpi
@@ -29,13 +51,31 @@ func NewKPICalculator(prs []PR) *KPICalculator {
 func (kpi *KPICalculator) calc() {
 	for _, pr := range kpi.prs {
 		kpi.commits = append(kpi.commits, float64(pr.Commits))
-		kpi.changes = append(kpi.changes, float64(pr.ChangedLines))
+		if !pr.SizeUnknown {
+			kpi.changes = append(kpi.changes, float64(pr.ChangedLines))
+		}
 		kpi.timeToMerge = append(kpi.timeToMerge, float64(pr.TimeToMerge()))
 		kpi.timeToReview = append(kpi.timeToReview, float64(pr.TimeToReview()))
-		kpi.timeToFirstReview = append(kpi.timeToFirstReview, float64(pr.TimeToFirstReview()))
+		kpi.timeToFirstReview = append(kpi.timeToFirstReview, float64(pr.TimeToFirstReview(kpi.opts)))
 		kpi.lastReviewToMerge = append(kpi.lastReviewToMerge, float64(pr.LastReviewToMerge()))
-		kpi.pRLeadTime = append(kpi.pRLeadTime, float64(pr.PRLeadTime()))
-		kpi.reviews = append(kpi.reviews, float64(pr.ReviewComments))
+		kpi.pRLeadTime = append(kpi.pRLeadTime, float64(pr.PRLeadTime(kpi.opts)))
+		kpi.reviews = append(kpi.reviews, float64(pr.HumanReviewComments(kpi.opts)))
+		if !pr.CommentsUnknown {
+			kpi.conversation = append(kpi.conversation, float64(pr.ConversationComments(kpi.opts)))
+			kpi.botComments = append(kpi.botComments, float64(pr.BotComments(kpi.opts)))
+		}
+		kpi.timeToApproval = append(kpi.timeToApproval, float64(pr.TimeToFirstApproval(kpi.opts)))
+		kpi.approvalToMerge = append(kpi.approvalToMerge, float64(pr.ApprovalToMerge()))
+		kpi.approvals = append(kpi.approvals, float64(pr.ApprovalsCount()))
+		kpi.changesRequested = append(kpi.changesRequested, float64(pr.ChangesRequestedCount()))
+		kpi.requestLatency = append(kpi.requestLatency, float64(pr.ReviewRequestLatency()))
+		kpi.timeInDraft = append(kpi.timeInDraft, float64(pr.TimeInDraft()))
+		for _, l := range pr.ReviewRequestLatencies() {
+			if kpi.reviewerLatencies == nil {
+				kpi.reviewerLatencies = map[string][]float64{}
+			}
+			kpi.reviewerLatencies[l.Reviewer] = append(kpi.reviewerLatencies[l.Reviewer], float64(l.Latency))
+		}
 
 	}
 
@@ -115,6 +155,109 @@ func (kpi *KPICalculator) MedianReviews() float64 {
 	return m
 }
 
+func (kpi *KPICalculator) AvgConversationComments() float64 {
+	avg, _ := stats.Mean(kpi.conversation)
+	return avg
+}
+
+func (kpi *KPICalculator) MedianConversationComments() float64 {
+	m, _ := stats.Median(kpi.conversation)
+	return m
+}
+
+func (kpi *KPICalculator) AvgBotComments() float64 {
+	avg, _ := stats.Mean(kpi.botComments)
+	return avg
+}
+
+func (kpi *KPICalculator) MedianBotComments() float64 {
+	m, _ := stats.Median(kpi.botComments)
+	return m
+}
+
+func (kpi *KPICalculator) AvgTimeToFirstApproval() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.timeToApproval, stats.Mean)
+}
+
+func (kpi *KPICalculator) MedianTimeToFirstApproval() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.timeToApproval, stats.Median)
+}
+
+func (kpi *KPICalculator) AvgApprovalToMerge() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.approvalToMerge, stats.Mean)
+}
+
+func (kpi *KPICalculator) MedianApprovalToMerge() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.approvalToMerge, stats.Median)
+}
+
+func (kpi *KPICalculator) AvgApprovals() float64 {
+	avg, _ := stats.Mean(kpi.approvals)
+	return avg
+}
+
+func (kpi *KPICalculator) MedianApprovals() float64 {
+	m, _ := stats.Median(kpi.approvals)
+	return m
+}
+
+func (kpi *KPICalculator) AvgChangesRequested() float64 {
+	avg, _ := stats.Mean(kpi.changesRequested)
+	return avg
+}
+
+func (kpi *KPICalculator) MedianChangesRequested() float64 {
+	m, _ := stats.Median(kpi.changesRequested)
+	return m
+}
+
+func (kpi *KPICalculator) AvgReviewRequestLatency() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.requestLatency, stats.Mean)
+}
+
+func (kpi *KPICalculator) MedianReviewRequestLatency() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.requestLatency, stats.Median)
+}
+
+func (kpi *KPICalculator) AvgTimeInDraft() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.timeInDraft, stats.Mean)
+}
+
+func (kpi *KPICalculator) MedianTimeInDraft() time.Duration {
+	return timeStatsWithoutZeroDurations(kpi.timeInDraft, stats.Median)
+}
+
+// ReviewerLatency sums up how fast a reviewer answers review requests.
+type ReviewerLatency struct {
+	Reviewer string
+	Answered int
+	Avg      time.Duration
+	Median   time.Duration
+}
+
+// ReviewerLatencies returns the review request latency of every reviewer,
+// slowest first.
+func (kpi *KPICalculator) ReviewerLatencies() []ReviewerLatency {
+	var latencies []ReviewerLatency
+	for reviewer, durs := range kpi.reviewerLatencies {
+		avg, _ := stats.Mean(durs)
+		median, _ := stats.Median(durs)
+		latencies = append(latencies, ReviewerLatency{
+			Reviewer: reviewer,
+			Answered: len(durs),
+			Avg:      time.Duration(avg),
+			Median:   time.Duration(median),
+		})
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		if latencies[i].Median != latencies[j].Median {
+			return latencies[i].Median > latencies[j].Median
+		}
+		return latencies[i].Reviewer < latencies[j].Reviewer
+	})
+	return latencies
+}
+
 func timeStatsWithoutZeroDurations(durs []float64, statFunc func(stats.Float64Data) (float64, error)) time.Duration {
 	filtered := make([]float64, 0, len(durs))
 	for _, d := range durs {
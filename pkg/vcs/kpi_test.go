@@ -0,0 +1,55 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReviewerLatencies(t *testing.T) {
+	answered := func(reviewer string, after int) PR {
+		return PR{
+			ReviewRequests: []ReviewRequest{{Reviewer: reviewer, RequestedAt: hour(0)}},
+			Reviews:        []Review{{Author: reviewer, SubmittedAt: hour(after)}},
+		}
+	}
+	prs := []PR{
+		answered("bob", 1),
+		answered("eve", 2),
+		answered("bob", 5),
+		answered("ann", 3),
+		answered("joe", 3),
+		{ReviewRequests: []ReviewRequest{{Reviewer: "zoe", RequestedAt: hour(0)}}}, // never answered
+	}
+	got := NewKPICalculator(prs, KPIOptions{}).ReviewerLatencies()
+	want := []ReviewerLatency{
+		{Reviewer: "ann", Answered: 1, Avg: 3 * time.Hour, Median: 3 * time.Hour},
+		{Reviewer: "bob", Answered: 2, Avg: 3 * time.Hour, Median: 3 * time.Hour},
+		{Reviewer: "joe", Answered: 1, Avg: 3 * time.Hour, Median: 3 * time.Hour},
+		{Reviewer: "eve", Answered: 1, Avg: 2 * time.Hour, Median: 2 * time.Hour},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReviewerLatencies() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCommentStatsSkipUnknownComments(t *testing.T) {
+	prs := []PR{
+		{Comments: []Comment{
+			{Author: "bob", Kind: CommentConversation},
+			{Author: "bob", Kind: CommentConversation},
+			{Author: "ci[bot]", Kind: CommentConversation},
+		}},
+		{ReviewComments: 3, CommentsUnknown: true},
+	}
+	kpi := NewKPICalculator(prs, KPIOptions{})
+	if avg := kpi.AvgConversationComments(); avg != 2 {
+		t.Errorf("conversation comments = %.2f, want 2 from the PR listing them", avg)
+	}
+	if avg := kpi.AvgBotComments(); avg != 1 {
+		t.Errorf("bot comments = %.2f, want 1 from the PR listing them", avg)
+	}
+	if avg := kpi.AvgReviews(); avg != 1.5 {
+		t.Errorf("review comments = %.2f, want 1.5 with the counted ones", avg)
+	}
+}
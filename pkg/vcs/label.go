@@ -0,0 +1,43 @@
+package vcs
+
+import "strings"
+
+// ParseLabels splits a comma separated list of labels.
+func ParseLabels(list string) []string {
+	return splitList(list)
+}
+
+// HasLabel reports whether the PR carries label. Labels are compared
+// case-insensitively, as GitHub and GitLab do.
+func (pr *PR) HasLabel(label string) bool {
+	for _, l := range pr.Labels {
+		if strings.EqualFold(l, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterLabels keeps the PRs carrying any of include, or all of them when
+// include is empty, and none of exclude.
+func FilterLabels(prs []PR, include, exclude []string) []PR {
+	if len(include) == 0 && len(exclude) == 0 {
+		return prs
+	}
+	var filtered []PR
+	for _, pr := range prs {
+		if (len(include) == 0 || pr.hasAnyLabel(include)) && !pr.hasAnyLabel(exclude) {
+			filtered = append(filtered, pr)
+		}
+	}
+	return filtered
+}
+
+func (pr *PR) hasAnyLabel(labels []string) bool {
+	for _, l := range labels {
+		if pr.HasLabel(l) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterLabels(t *testing.T) {
+	prs := []PR{
+		{Number: 1, Labels: []string{"Bug"}},
+		{Number: 2, Labels: []string{"bug", "dependencies"}},
+		{Number: 3, Labels: []string{"feature"}},
+		{Number: 4},
+	}
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []int
+	}{
+		{"no filter", nil, nil, []int{1, 2, 3, 4}},
+		{"include any", []string{"bug", "feature"}, nil, []int{1, 2, 3}},
+		{"include ignores case", []string{"BUG"}, nil, []int{1, 2}},
+		{"exclude", nil, []string{"Dependencies"}, []int{1, 3, 4}},
+		{"exclude wins over include", []string{"bug"}, []string{"dependencies"}, []int{1}},
+		{"nothing left", []string{"docs"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := numbers(FilterLabels(prs, tt.include, tt.exclude))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterLabels(%q, %q) = %v, want %v", tt.include, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package vcs
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
+// Client fetches PRs from a VCS provider. GetMergedPRList selects the PRs
+// merged into any of bases, which are matched with MatchBase. When ctx is
+// cancelled, it returns the PRs fetched so far along with the context error.
 type Client interface {
-	GetMergedPRList(owner string, repo string, from time.Time, to time.Time, base string) ([]PR, error)
-	GetPRInfo(owner string, repo string, prNum int) (PR, error)
+	GetMergedPRList(ctx context.Context, owner string, repo string, from time.Time, to time.Time, bases []string) ([]PR, error)
+	GetPRInfo(ctx context.Context, owner string, repo string, prNum int) (PR, error)
 }
 
 type PR struct {
@@ -22,15 +28,183 @@ type PR struct {
 	LastCommitAt   time.Time
 	FirstCommentAt time.Time
 	LastCommentAt  time.Time
+	Reviews        []Review
+	ReviewRequests []ReviewRequest
+	// Comments are the comments left on the PR, for the providers that list
+	// them with their authors. CommentsUnknown is set by the others, whose
+	// conversation and bot comments are then left out of the comment stats.
+	Comments        []Comment
+	CommentsUnknown bool
+	// Draft is set when the PR was opened as a draft, and ReadyForReviewAt
+	// to when it was then marked as ready for review.
+	Draft            bool
+	ReadyForReviewAt time.Time
+	// Labels are the names of the labels of the PR; providers without labels
+	// fill in their closest equivalent, such as Gerrit hashtags.
+	Labels    []string
+	Milestone string
+	// SizeUnknown is set when the provider can't count the ChangedLines,
+	// which are then left out of the size stats.
+	SizeUnknown bool
+	// Warnings are the problems met while fetching the PR. The fields they
+	// concern are left empty instead of failing the whole run.
+	Warnings []string
 }
 
-func (pr *PR) PRLeadTime() time.Duration {
-	return pr.MergedAt.Sub(pr.CreatedAt)
+// ReviewStartAt returns when the review KPIs of the PR start.
+func (pr *PR) ReviewStartAt(opts KPIOptions) time.Time {
+	if opts.AnchorAtReady && !pr.ReadyForReviewAt.IsZero() {
+		return pr.ReadyForReviewAt
+	}
+	return pr.CreatedAt
+}
+
+// TimeInDraft is the time from the opening of a draft PR to when it was
+// marked as ready for review.
+func (pr *PR) TimeInDraft() time.Duration {
+	if !pr.Draft || pr.ReadyForReviewAt.IsZero() {
+		return 0
+	}
+	return pr.ReadyForReviewAt.Sub(pr.CreatedAt)
+}
+
+// FirstApprovalAt returns when the PR was first approved, or the zero time
+// when it never was.
+func (pr *PR) FirstApprovalAt() time.Time {
+	var first time.Time
+	for _, r := range pr.Reviews {
+		if r.State == ReviewApproved && (first.IsZero() || r.SubmittedAt.Before(first)) {
+			first = r.SubmittedAt
+		}
+	}
+	return first
+}
+
+func (pr *PR) TimeToFirstApproval(opts KPIOptions) time.Duration {
+	approvedAt := pr.FirstApprovalAt()
+	if approvedAt.IsZero() || approvedAt.Before(pr.ReviewStartAt(opts)) {
+		return 0
+	}
+	return approvedAt.Sub(pr.ReviewStartAt(opts))
+}
+
+// ApprovalToMerge is the time from the first approval to the merge, so that
+// it adds up to PRLeadTime with TimeToFirstApproval.
+func (pr *PR) ApprovalToMerge() time.Duration {
+	approvedAt := pr.FirstApprovalAt()
+	if approvedAt.IsZero() || approvedAt.After(pr.MergedAt) {
+		return 0
+	}
+	return pr.MergedAt.Sub(approvedAt)
+}
+
+func (pr *PR) ApprovalsCount() int {
+	return pr.countReviews(ReviewApproved)
+}
+
+func (pr *PR) ChangesRequestedCount() int {
+	return pr.countReviews(ReviewChangesRequested)
+}
+
+func (pr *PR) countReviews(state string) int {
+	n := 0
+	for _, r := range pr.Reviews {
+		if r.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+// ReviewRequestLatencies returns the time each requested reviewer took to
+// submit a review, for the requests that were answered before being
+// withdrawn or renewed.
+func (pr *PR) ReviewRequestLatencies() []RequestLatency {
+	var latencies []RequestLatency
+	for i, req := range pr.ReviewRequests {
+		var answeredAt time.Time
+		for _, r := range pr.Reviews {
+			if r.Author == req.Reviewer && !r.SubmittedAt.Before(req.RequestedAt) && (answeredAt.IsZero() || r.SubmittedAt.Before(answeredAt)) {
+				answeredAt = r.SubmittedAt
+			}
+		}
+		if answeredAt.IsZero() || (!req.RemovedAt.IsZero() && req.RemovedAt.Before(answeredAt)) {
+			continue
+		}
+		renewed := false
+		for _, next := range pr.ReviewRequests[i+1:] {
+			renewed = renewed || (next.Reviewer == req.Reviewer && next.RequestedAt.Before(answeredAt))
+		}
+		if !renewed {
+			latencies = append(latencies, RequestLatency{Reviewer: req.Reviewer, Latency: answeredAt.Sub(req.RequestedAt)})
+		}
+	}
+	return latencies
+}
+
+// ReviewRequestLatency is the mean time the requested reviewers of the PR
+// took to answer.
+func (pr *PR) ReviewRequestLatency() time.Duration {
+	latencies := pr.ReviewRequestLatencies()
+	if len(latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, l := range latencies {
+		total += l.Latency
+	}
+	return total / time.Duration(len(latencies))
+}
+
+// Review states, following the GitHub ones. Providers map their own review
+// outcomes onto them.
+const (
+	ReviewApproved         = "APPROVED"
+	ReviewChangesRequested = "CHANGES_REQUESTED"
+	ReviewCommented        = "COMMENTED"
+	ReviewDismissed        = "DISMISSED"
+)
+
+// Review is a review submitted on a PR.
+type Review struct {
+	Author      string
+	State       string
+	SubmittedAt time.Time
+	BodyLength  int
+}
+
+// ReviewSpan returns the submission times of the first and last reviews.
+func ReviewSpan(reviews []Review) (first time.Time, last time.Time) {
+	for _, r := range reviews {
+		if r.SubmittedAt.IsZero() {
+			continue
+		}
+		if first.IsZero() || r.SubmittedAt.Before(first) {
+			first = r.SubmittedAt
+		}
+		if r.SubmittedAt.After(last) {
+			last = r.SubmittedAt
+		}
+	}
+	return
+}
+
+func (pr *PR) PRLeadTime(opts KPIOptions) time.Duration {
+	if pr.CreatedAt.IsZero() { // creation unknown, e.g. squashed PRs read from git
+		return 0
+	}
+	return pr.MergedAt.Sub(pr.ReviewStartAt(opts))
 }
 
 func (pr *PR) TimeToMerge() time.Duration {
+	if pr.CreatedAt.IsZero() {
+		return 0
+	}
+	createToMerge := pr.MergedAt.Sub(pr.CreatedAt)
+	if pr.FirstCommitAt.IsZero() { // commits could not be fetched
+		return createToMerge
+	}
 	firstCommitToMerge := pr.MergedAt.Sub(pr.FirstCommitAt)
-	createToMerge := pr.PRLeadTime()
 	if firstCommitToMerge < createToMerge { // commits probably re-written during review
 		return createToMerge
 	}
@@ -40,11 +214,11 @@ func (pr *PR) TimeToMerge() time.Duration {
 func (pr *PR) TimeToReview() time.Duration {
 	return pr.LastCommentAt.Sub(pr.FirstCommentAt)
 }
-func (pr *PR) TimeToFirstReview() time.Duration {
-	if pr.FirstCommentAt.IsZero() {
+func (pr *PR) TimeToFirstReview(opts KPIOptions) time.Duration {
+	if pr.FirstCommentAt.IsZero() || pr.FirstCommentAt.Before(pr.ReviewStartAt(opts)) {
 		return 0
 	}
-	return pr.FirstCommentAt.Sub(pr.CreatedAt)
+	return pr.FirstCommentAt.Sub(pr.ReviewStartAt(opts))
 }
 func (pr *PR) LastReviewToMerge() time.Duration {
 	if pr.LastCommentAt.IsZero() || pr.LastCommentAt.After(pr.MergedAt) {
@@ -52,3 +226,17 @@ func (pr *PR) LastReviewToMerge() time.Duration {
 	}
 	return pr.MergedAt.Sub(pr.LastCommentAt)
 }
+
+// ReviewRequest is a request for a reviewer to review a PR, in request
+// order. RemovedAt is set when the request was withdrawn.
+type ReviewRequest struct {
+	Reviewer    string
+	RequestedAt time.Time
+	RemovedAt   time.Time
+}
+
+// RequestLatency is the time a reviewer took to answer a review request.
+type RequestLatency struct {
+	Reviewer string
+	Latency  time.Duration
+}
@@ -0,0 +1,84 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func hour(h int) time.Time {
+	return time.Date(2020, 8, 1, h, 0, 0, 0, time.UTC)
+}
+
+func TestReviewRequestLatencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []ReviewRequest
+		reviews  []Review
+		want     []RequestLatency
+	}{
+		{
+			name:     "answered",
+			requests: []ReviewRequest{{Reviewer: "bob", RequestedAt: hour(1)}},
+			reviews:  []Review{{Author: "bob", SubmittedAt: hour(4)}, {Author: "bob", SubmittedAt: hour(6)}},
+			want:     []RequestLatency{{"bob", 3 * time.Hour}},
+		},
+		{
+			name:     "reviewed before the request",
+			requests: []ReviewRequest{{Reviewer: "bob", RequestedAt: hour(3)}},
+			reviews:  []Review{{Author: "bob", SubmittedAt: hour(2)}, {Author: "bob", SubmittedAt: hour(5)}},
+			want:     []RequestLatency{{"bob", 2 * time.Hour}},
+		},
+		{
+			name:     "never answered",
+			requests: []ReviewRequest{{Reviewer: "bob", RequestedAt: hour(1)}},
+			reviews:  []Review{{Author: "eve", SubmittedAt: hour(4)}},
+		},
+		{
+			name:     "removed before the answer",
+			requests: []ReviewRequest{{Reviewer: "bob", RequestedAt: hour(1), RemovedAt: hour(2)}},
+			reviews:  []Review{{Author: "bob", SubmittedAt: hour(4)}},
+		},
+		{
+			name:     "removed after the answer",
+			requests: []ReviewRequest{{Reviewer: "bob", RequestedAt: hour(1), RemovedAt: hour(5)}},
+			reviews:  []Review{{Author: "bob", SubmittedAt: hour(4)}},
+			want:     []RequestLatency{{"bob", 3 * time.Hour}},
+		},
+		{
+			name: "re-requested before the answer",
+			requests: []ReviewRequest{
+				{Reviewer: "bob", RequestedAt: hour(1)},
+				{Reviewer: "bob", RequestedAt: hour(3)},
+			},
+			reviews: []Review{{Author: "bob", SubmittedAt: hour(4)}},
+			want:    []RequestLatency{{"bob", time.Hour}},
+		},
+		{
+			name: "re-requested after the answer",
+			requests: []ReviewRequest{
+				{Reviewer: "bob", RequestedAt: hour(1)},
+				{Reviewer: "bob", RequestedAt: hour(5)},
+			},
+			reviews: []Review{{Author: "bob", SubmittedAt: hour(4)}, {Author: "bob", SubmittedAt: hour(8)}},
+			want:    []RequestLatency{{"bob", 3 * time.Hour}, {"bob", 3 * time.Hour}},
+		},
+		{
+			name: "several reviewers",
+			requests: []ReviewRequest{
+				{Reviewer: "bob", RequestedAt: hour(1)},
+				{Reviewer: "eve", RequestedAt: hour(1)},
+			},
+			reviews: []Review{{Author: "eve", SubmittedAt: hour(2)}, {Author: "bob", SubmittedAt: hour(6)}},
+			want:    []RequestLatency{{"bob", 5 * time.Hour}, {"eve", time.Hour}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := PR{ReviewRequests: tt.requests, Reviews: tt.reviews}
+			if got := pr.ReviewRequestLatencies(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReviewRequestLatencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}